@@ -0,0 +1,387 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/shenwei356/bio/taxdump"
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// camiCompareCmd represents the cami-compare command
+var camiCompareCmd = &cobra.Command{
+	Use:   "cami-compare",
+	Short: "Compare CAMI metagenomic profiles with a gold standard",
+	Long: `Compare CAMI metagenomic profiles with a gold standard
+
+Input format: 
+  The CAMI (Taxonomic) Profiling Output Format    
+  - https://github.com/CAMI-challenge/contest_information/blob/master/file_formats/CAMI_TP_specification.mkd
+  - One file with mutiple samples is also supported.
+  - Samples of the predicted profile and the gold standard (-g/--gold-standard)
+    are paired by "@SampleID". If both files contain only one sample, they are
+    paired directly.
+
+Metrics (similar to OPAL):
+  Per rank:
+    tp, fp, fn      Numbers of true positive, false positive and false
+                    negative taxa. Predicted taxa with abundance below
+                    -t/--min-abundance are not counted.
+    precision       tp / (tp + fp), i.e., purity.
+    recall          tp / (tp + fn), i.e., completeness.
+    f1_score        2 * precision * recall / (precision + recall).
+    l1_norm         Sum of absolute differences of relative abundances,
+                    which are renormalized to sum up to 1 at each rank,
+                    ranging from 0 to 2.
+    bray_curtis     Bray-Curtis distance, ranging from 0 to 1.
+  Rank independent:
+    weighted_unifrac
+                    Weighted UniFrac error, i.e., the earth mover's distance
+                    between the two profiles on the taxonomic tree from the
+                    taxdump files, with all branch lengths set to 1.
+                    Abundances of taxa not assigned to their descendants in
+                    the profile are used and normalized.
+
+  "NA" is outputted for undefined values, e.g., precision with no predictions.
+
+Output format:
+  Tab-delimited format with a header row: sample, rank, metric, value.
+
+Attention:
+  1. Merged TaxIds are replaced with new ones according to the taxdump files,
+     and TaxIds not found in the taxdump are attached to the root in computing
+     UniFrac.
+  2. Ranks "domain" and "acellular root" (Viruses) in profiles created with
+     NCBI taxdump files since 2025 are treated as "superkingdom" if it's in
+     -r/--rank.
+
+Examples:
+  taxonkit cami-compare -g gold.profile predicted.profile -o metrics.tsv
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+
+		fields := getCAMIFields(cmd)
+		goldFile := getFlagString(cmd, "gold-standard")
+		if goldFile == "" {
+			checkError(fmt.Errorf("flag -g/--gold-standard needed"))
+		}
+		ranks := getFlagStringSlice(cmd, "rank")
+		if len(ranks) == 0 {
+			checkError(fmt.Errorf("flag -r/--rank needed"))
+		}
+		minAbundance := getFlagNonNegativeFloat64(cmd, "min-abundance")
+
+		// aliases of ranks, e.g., domain -> superkingdom
+		aliases := rankAliasMap(ranks)
+
+		files := getFileList(args)
+
+		if len(files) > 1 {
+			checkError(fmt.Errorf("only one input file allowed"))
+		}
+
+		if len(files) == 1 && isStdin(files[0]) && !xopen.IsStdin() {
+			checkError(fmt.Errorf("stdin not detected"))
+		}
+
+		// ----------------------------------------------------------------
+
+		golds, err := readCAMIProfile(goldFile, fields)
+		checkError(err)
+		preds, err := readCAMIProfile(files[0], fields)
+		checkError(err)
+
+		type samplePair struct {
+			gold, pred *CAMISample
+		}
+		pairs := make([]samplePair, 0, len(preds))
+		if len(golds) == 1 && len(preds) == 1 {
+			pairs = append(pairs, samplePair{golds[0], preds[0]})
+		} else {
+			id2gold := make(map[string]*CAMISample, len(golds))
+			for _, sample := range golds {
+				id2gold[sample.SampleID] = sample
+			}
+			for _, sample := range preds {
+				gold, ok := id2gold[sample.SampleID]
+				if !ok {
+					log.Warningf("sample not found in the gold standard: %s", sample.SampleID)
+					continue
+				}
+				pairs = append(pairs, samplePair{gold, sample})
+			}
+		}
+		if len(pairs) == 0 {
+			checkError(fmt.Errorf("no samples paired with the gold standard"))
+		}
+		if config.Verbose {
+			log.Infof("%d samples paired with the gold standard", len(pairs))
+		}
+
+		taxondb := loadTaxonomy(&config, false, false)
+
+		// TaxIds in TAXPATH are also updated, which are used to find parents
+		updateTaxids := func(sample *CAMISample) {
+			var _taxid int
+			var err error
+			for _, r := range sample.Records {
+				if taxid, ok := taxondb.MergeNodes[r.Taxid]; ok {
+					r.Taxid = taxid
+				}
+				for i, s := range r.TaxPath {
+					if _taxid, err = strconv.Atoi(s); err != nil {
+						continue
+					}
+					if taxid, ok := taxondb.MergeNodes[uint32(_taxid)]; ok {
+						r.TaxPath[i] = strconv.Itoa(int(taxid))
+					}
+				}
+				if _rank, ok := aliases[r.Rank]; ok {
+					r.Rank = _rank
+				}
+			}
+		}
+
+		// ----------------------------------------------------------------
+
+		outfh, err := xopen.Wopen(config.OutFile)
+		checkError(err)
+		defer outfh.Close()
+
+		outfh.WriteString("sample\trank\tmetric\tvalue\n")
+
+		formatValue := func(v float64) string {
+			if math.IsNaN(v) {
+				return "NA"
+			}
+			return strconv.FormatFloat(v, 'f', 6, 64)
+		}
+
+		var g, p map[uint32]float64
+		var tp, fp, fn int
+		var precision, recall, f1, l1, sumDiff, sum float64
+		var ok bool
+		for _, pair := range pairs {
+			updateTaxids(pair.gold)
+			updateTaxids(pair.pred)
+
+			gRanks := camiRankAbundances(pair.gold)
+			pRanks := camiRankAbundances(pair.pred)
+
+			for _, rank := range ranks {
+				g, p = gRanks[rank], pRanks[rank]
+				if len(g) == 0 && len(p) == 0 {
+					continue
+				}
+
+				tp, fp, fn = 0, 0, 0
+				for taxid, v := range p {
+					if v < minAbundance {
+						continue
+					}
+					if _, ok = g[taxid]; ok {
+						tp++
+					} else {
+						fp++
+					}
+				}
+				for taxid := range g {
+					if v, ok := p[taxid]; !ok || v < minAbundance {
+						fn++
+					}
+				}
+
+				precision = math.NaN()
+				if tp+fp > 0 {
+					precision = float64(tp) / float64(tp+fp)
+				}
+				recall = math.NaN()
+				if tp+fn > 0 {
+					recall = float64(tp) / float64(tp+fn)
+				}
+				f1 = math.NaN()
+				if !math.IsNaN(precision) && !math.IsNaN(recall) {
+					if precision+recall > 0 {
+						f1 = 2 * precision * recall / (precision + recall)
+					} else {
+						f1 = 0
+					}
+				}
+
+				sumDiff, sum = 0, 0
+				for taxid, v := range p {
+					sumDiff += math.Abs(v - g[taxid])
+					sum += v
+				}
+				for taxid, v := range g {
+					if _, ok = p[taxid]; !ok {
+						sumDiff += v
+					}
+					sum += v
+				}
+				l1 = camiL1Norm(g, p)
+
+				for _, m := range []struct {
+					metric string
+					value  string
+				}{
+					{"tp", strconv.Itoa(tp)},
+					{"fp", strconv.Itoa(fp)},
+					{"fn", strconv.Itoa(fn)},
+					{"precision", formatValue(precision)},
+					{"recall", formatValue(recall)},
+					{"f1_score", formatValue(f1)},
+					{"l1_norm", formatValue(l1)},
+					{"bray_curtis", formatValue(sumDiff / sum)},
+				} {
+					fmt.Fprintf(outfh, "%s\t%s\t%s\t%s\n", pair.pred.SampleID, rank, m.metric, m.value)
+				}
+			}
+
+			fmt.Fprintf(outfh, "%s\t%s\t%s\t%s\n", pair.pred.SampleID, "rank independent", "weighted_unifrac",
+				formatValue(weightedUniFrac(taxondb, camiAssignedAbundances(pair.gold), camiAssignedAbundances(pair.pred))))
+
+			if config.LineBuffered {
+				outfh.Flush()
+			}
+		}
+	},
+}
+
+// camiRankAbundances groups abundances of a sample by ranks.
+func camiRankAbundances(sample *CAMISample) map[string]map[uint32]float64 {
+	m := make(map[string]map[uint32]float64, 8)
+	var ok bool
+	for _, r := range sample.Records {
+		if _, ok = m[r.Rank]; !ok {
+			m[r.Rank] = make(map[uint32]float64, 128)
+		}
+		m[r.Rank][r.Taxid] += r.Abundance
+	}
+	return m
+}
+
+// camiL1Norm computes the L1 norm of abundances of a rank,
+// which are renormalized to sum up to 1 first.
+func camiL1Norm(g, p map[uint32]float64) float64 {
+	var sumG, sumP float64
+	for _, v := range g {
+		sumG += v
+	}
+	for _, v := range p {
+		sumP += v
+	}
+
+	fraction := func(v, sum float64) float64 {
+		if sum == 0 {
+			return 0
+		}
+		return v / sum
+	}
+
+	var l1 float64
+	for taxid, v := range p {
+		l1 += math.Abs(fraction(v, sumP) - fraction(g[taxid], sumG))
+	}
+	for taxid, v := range g {
+		if _, ok := p[taxid]; !ok {
+			l1 += fraction(v, sumG)
+		}
+	}
+	return l1
+}
+
+// camiAssignedAbundances returns abundances of taxa not assigned to
+// their descendants in the profile, which are normalized to sum up to 1.
+func camiAssignedAbundances(sample *CAMISample) map[uint32]float64 {
+	parents := sample.Parents()
+
+	m := make(map[uint32]float64, len(sample.Records))
+	for _, r := range sample.Records {
+		m[r.Taxid] += r.Abundance
+	}
+	for _, r := range sample.Records {
+		if p := parents[r.Taxid]; p > 0 {
+			m[p] -= r.Abundance
+		}
+	}
+
+	var sum float64
+	for taxid, v := range m {
+		if v <= 1e-12 {
+			delete(m, taxid)
+			continue
+		}
+		sum += v
+	}
+	for taxid := range m {
+		m[taxid] /= sum
+	}
+	return m
+}
+
+// weightedUniFrac computes the earth mover's distance of two profiles on
+// the taxonomic tree with unit branch lengths.
+func weightedUniFrac(taxondb *taxdump.Taxonomy, a, b map[uint32]float64) float64 {
+	// difference of abundances in the subtree under the branch of each node
+	diffs := make(map[uint32]float64, 1024)
+
+	add := func(taxid uint32, v float64) {
+		lineage := taxondb.LineageTaxIds(taxid)
+		if lineage == nil { // not found, attached to the root
+			lineage = []uint32{taxid}
+		}
+		for _, t := range lineage {
+			if t == 1 {
+				continue
+			}
+			diffs[t] += v
+		}
+	}
+	for taxid, v := range a {
+		add(taxid, v)
+	}
+	for taxid, v := range b {
+		add(taxid, -v)
+	}
+
+	var d float64
+	for _, v := range diffs {
+		d += math.Abs(v)
+	}
+	return d
+}
+
+func init() {
+	RootCmd.AddCommand(camiCompareCmd)
+
+	addCAMIFieldFlags(camiCompareCmd)
+
+	camiCompareCmd.Flags().StringP("gold-standard", "g", "", "CAMI profile of the gold standard")
+	camiCompareCmd.Flags().StringSliceP("rank", "r", []string{"superkingdom", "phylum", "class", "order", "family", "genus", "species", "strain"}, "ranks to compare")
+	camiCompareCmd.Flags().Float64P("min-abundance", "t", 0, "predicted taxa with abundance (percentage) below this value are not counted in tp and fp")
+}
@@ -0,0 +1,531 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// camiConvertCmd represents the cami-convert command
+var camiConvertCmd = &cobra.Command{
+	Use:   "cami-convert",
+	Short: "Convert CAMI metagenomic profiles to Krona, BIOM and MetaPhlAn formats",
+	Long: `Convert CAMI metagenomic profiles to Krona, BIOM and MetaPhlAn formats
+
+Input format: 
+  The CAMI (Taxonomic) Profiling Output Format    
+  - https://github.com/CAMI-challenge/contest_information/blob/master/file_formats/CAMI_TP_specification.mkd
+  - One file with mutiple samples is also supported.
+
+Output formats (-f/--format):
+  krona       Krona text format for "ktImportText", one sample only.
+              Each line contains the abundance assigned to a taxon but not to
+              its descendants in the profile, followed by names of the lineage.
+  krona-html  Krona HTML file, which can be opened in a web browser directly.
+              Multiple samples are shown as datasets. Krona resources are
+              loaded from http://marbl.github.io/Krona.
+  biom        BIOM JSON format (version 1.0), with "taxonomy" and "rank"
+              in the metadata of rows (taxa).
+  metaphlan   MetaPhlAn-style table, with the lineage (clade_name) in the
+              format of "d__Bacteria|p__Pseudomonadota|...", TaxIds of the
+              lineage (NCBI_tax_id), and abundances of samples.
+
+Attention:
+  1. No extra taxonomy data needed, ranks, TAXPATH and TAXPATHSN in the profile
+     are used as they are.
+  2. Abundances of all ranks in the profile are preserved. Ranks of items in
+     TAXPATH are taken from records of these TaxIds in the profile, or
+     assigned according to the "@Ranks" header if TAXPATH keeps missing
+     ranks as empty items.
+  3. Records with zero abundance are ignored.
+
+Examples:
+  1. Krona text and HTML:
+      taxonkit cami-convert -f krona test.profile -o test.krona.txt
+      ktImportText test.krona.txt -o test.krona.html
+      
+      taxonkit cami-convert -f krona-html test.profile -o test.krona.html
+  2. BIOM:
+      taxonkit cami-convert -f biom test.profile -o test.biom
+  3. MetaPhlAn-style table of the sample S1 in a multi-sample file:
+      taxonkit cami-convert -f metaphlan -s S1 samples.profile
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+
+		fields := getCAMIFields(cmd)
+		format := strings.ToLower(getFlagString(cmd, "format"))
+		sampleIDs := getFlagStringSlice(cmd, "sample-id")
+
+		switch format {
+		case "krona", "krona-html", "biom", "metaphlan":
+		default:
+			checkError(fmt.Errorf("invalid output format: %s, available: krona, krona-html, biom, metaphlan", format))
+		}
+
+		files := getFileList(args)
+
+		if len(files) > 1 {
+			checkError(fmt.Errorf("only one input file allowed"))
+		}
+
+		if len(files) == 1 && isStdin(files[0]) && !xopen.IsStdin() {
+			checkError(fmt.Errorf("stdin not detected"))
+		}
+
+		// ----------------------------------------------------------------
+
+		samples, err := readCAMIProfile(files[0], fields)
+		checkError(err)
+
+		if len(sampleIDs) > 0 {
+			id2sample := make(map[string]*CAMISample, len(samples))
+			for _, sample := range samples {
+				id2sample[sample.SampleID] = sample
+			}
+			samples = samples[:0]
+			for _, id := range sampleIDs {
+				sample, ok := id2sample[id]
+				if !ok {
+					checkError(fmt.Errorf("sample not found: %s", id))
+				}
+				samples = append(samples, sample)
+			}
+		}
+
+		if len(samples) == 0 {
+			log.Warningf("no samples found in %s", files[0])
+			return
+		}
+		if config.Verbose {
+			log.Infof("%d samples loaded", len(samples))
+		}
+
+		if format == "krona" && len(samples) > 1 {
+			checkError(fmt.Errorf("Krona text format supports only one sample, %d samples found. please choose one with -s/--sample-id, or use -f krona-html", len(samples)))
+		}
+
+		taxa, roots := newCAMITaxa(samples)
+
+		// ----------------------------------------------------------------
+
+		outfh, err := xopen.Wopen(config.OutFile)
+		checkError(err)
+		defer outfh.Close()
+
+		switch format {
+		case "krona":
+			writeKronaText(outfh, roots)
+		case "krona-html":
+			writeKronaHTML(outfh, samples, roots)
+		case "biom":
+			checkError(writeBIOM(outfh, samples, taxa))
+		case "metaphlan":
+			writeMetaPhlAnTable(outfh, samples, taxa)
+		}
+	},
+}
+
+// camiTaxon is a taxon in one or more CAMI samples.
+type camiTaxon struct {
+	Taxid      uint32
+	Rank       string
+	Name       string
+	TaxPath    []string
+	TaxPathSN  []string
+	Ranks      []string  // ranks of items in TaxPath
+	Abundances []float64 // abundances in all samples
+
+	Children []*camiTaxon
+	Totals   []float64 // max(abundance, sum of totals of children) in all samples
+}
+
+// lineage returns MetaPhlAn-style names and TaxIds of the lineage.
+func (t *camiTaxon) lineage() ([]string, []string) {
+	names := make([]string, 0, len(t.TaxPathSN))
+	taxids := make([]string, 0, len(t.TaxPath))
+	for i, name := range t.TaxPathSN {
+		if name == "" {
+			continue
+		}
+		names = append(names, camiRankPrefix(t.Ranks[i])+strings.ReplaceAll(name, " ", "_"))
+		if i < len(t.TaxPath) {
+			taxids = append(taxids, t.TaxPath[i])
+		} else {
+			taxids = append(taxids, "")
+		}
+	}
+	return names, taxids
+}
+
+var defaultCAMIRanks = []string{"superkingdom", "phylum", "class", "order", "family", "genus", "species", "strain"}
+
+// newCAMITaxa merges taxa of samples and builds a tree from them.
+// Taxa are sorted by lineage length and then abundance in descending order.
+func newCAMITaxa(samples []*CAMISample) ([]*camiTaxon, []*camiTaxon) {
+	n := len(samples)
+	m := make(map[uint32]*camiTaxon, 1024)
+	taxa := make([]*camiTaxon, 0, 1024)
+
+	// ranks of TaxIds in profiles, for assigning ranks of items in TAXPATH
+	rankOf := make(map[string]string, 1024)
+	for _, sample := range samples {
+		for _, r := range sample.Records {
+			rankOf[strconv.Itoa(int(r.Taxid))] = r.Rank
+		}
+	}
+
+	var t *camiTaxon
+	var ok, aligned bool
+	var rank string
+	for j, sample := range samples {
+		ranks := sample.Ranks
+		if len(ranks) == 0 {
+			ranks = defaultCAMIRanks
+		}
+
+		for _, r := range sample.Records {
+			if t, ok = m[r.Taxid]; !ok {
+				t = &camiTaxon{
+					Taxid:      r.Taxid,
+					Rank:       r.Rank,
+					Name:       r.Name(),
+					TaxPath:    r.TaxPath,
+					TaxPathSN:  r.TaxPathSN,
+					Ranks:      make([]string, len(r.TaxPathSN)),
+					Abundances: make([]float64, n),
+					Totals:     make([]float64, n),
+				}
+
+				// positions in @Ranks are only reliable when missing ranks are
+				// kept as empty items, i.e., TAXPATH has the full length.
+				aligned = len(r.TaxPath) == len(ranks) ||
+					(len(r.TaxPath) <= len(ranks) && ranks[len(r.TaxPath)-1] == r.Rank)
+				for i := range t.Ranks {
+					if i == len(t.Ranks)-1 {
+						t.Ranks[i] = r.Rank
+						continue
+					}
+					if i < len(r.TaxPath) {
+						if rank, ok = rankOf[r.TaxPath[i]]; ok {
+							t.Ranks[i] = rank
+							continue
+						}
+					}
+					if aligned && i < len(ranks) {
+						t.Ranks[i] = ranks[i]
+					}
+				}
+				if t.Name == "" {
+					t.Name = strconv.Itoa(int(r.Taxid))
+				}
+
+				m[r.Taxid] = t
+				taxa = append(taxa, t)
+			}
+			t.Abundances[j] += r.Abundance
+		}
+	}
+
+	// tree
+	roots := make([]*camiTaxon, 0, 8)
+	var p *camiTaxon
+	var i, _taxid int
+	var err error
+	for _, t = range taxa {
+		p = nil
+		for i = len(t.TaxPath) - 2; i >= 0; i-- {
+			if _taxid, err = strconv.Atoi(t.TaxPath[i]); err != nil {
+				continue
+			}
+			if p, ok = m[uint32(_taxid)]; ok {
+				break
+			}
+		}
+		if p == nil {
+			roots = append(roots, t)
+		} else {
+			p.Children = append(p.Children, t)
+		}
+	}
+
+	var sumTotals func(t *camiTaxon)
+	sumTotals = func(t *camiTaxon) {
+		for _, c := range t.Children {
+			sumTotals(c)
+		}
+		for j := range t.Totals {
+			var sum float64
+			for _, c := range t.Children {
+				sum += c.Totals[j]
+			}
+			t.Totals[j] = math.Max(t.Abundances[j], sum)
+		}
+		sort.Slice(t.Children, func(a, b int) bool {
+			return sumFloats(t.Children[a].Totals) > sumFloats(t.Children[b].Totals)
+		})
+	}
+	for _, t = range roots {
+		sumTotals(t)
+	}
+	sort.Slice(roots, func(a, b int) bool {
+		return sumFloats(roots[a].Totals) > sumFloats(roots[b].Totals)
+	})
+
+	sort.Slice(taxa, func(a, b int) bool {
+		if len(taxa[a].TaxPath) != len(taxa[b].TaxPath) {
+			return len(taxa[a].TaxPath) < len(taxa[b].TaxPath)
+		}
+		sa, sb := sumFloats(taxa[a].Abundances), sumFloats(taxa[b].Abundances)
+		if sa != sb {
+			return sa > sb
+		}
+		return taxa[a].Taxid < taxa[b].Taxid
+	})
+
+	return taxa, roots
+}
+
+func sumFloats(values []float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum
+}
+
+// formatAbundance removes floating-point noise from summed abundances.
+func formatAbundance(v float64) string {
+	return strconv.FormatFloat(math.Round(v*1e12)/1e12, 'f', -1, 64)
+}
+
+func writeKronaText(outfh io.Writer, roots []*camiTaxon) {
+	names := make([]string, 0, 16)
+
+	var walk func(t *camiTaxon)
+	walk = func(t *camiTaxon) {
+		names = append(names, t.Name)
+
+		v := t.Totals[0]
+		for _, c := range t.Children {
+			v -= c.Totals[0]
+		}
+		if v > 1e-12 {
+			fmt.Fprintf(outfh, "%s\t%s\n", formatAbundance(v), strings.Join(names, "\t"))
+		}
+
+		for _, c := range t.Children {
+			walk(c)
+		}
+
+		names = names[:len(names)-1]
+	}
+	for _, t := range roots {
+		walk(t)
+	}
+}
+
+func writeKronaHTML(outfh io.Writer, samples []*CAMISample, roots []*camiTaxon) {
+	vals := func(values []float64) string {
+		var b strings.Builder
+		for _, v := range values {
+			b.WriteString("<val>" + formatAbundance(v) + "</val>")
+		}
+		return b.String()
+	}
+	repeat := func(s string) string {
+		return strings.Repeat("<val>"+html.EscapeString(s)+"</val>", len(samples))
+	}
+
+	fmt.Fprint(outfh, `<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Strict//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd">
+<html xmlns="http://www.w3.org/1999/xhtml" xml:lang="en" lang="en">
+ <head>
+  <meta charset="utf-8"/>
+  <link rel="shortcut icon" href="http://marbl.github.io/Krona/img/favicon.ico"/>
+  <script id="notfound">window.onload=function(){document.body.innerHTML="Could not get resources from \"http://marbl.github.io/Krona\"."}</script>
+  <script src="http://marbl.github.io/Krona/src/krona-2.0.js"></script>
+ </head>
+ <body>
+  <img id="hiddenImage" src="http://marbl.github.io/Krona/img/hidden.png" style="display:none"/>
+  <img id="loadingImage" src="http://marbl.github.io/Krona/img/loading.gif" style="display:none"/>
+  <noscript>Javascript must be enabled to view this page.</noscript>
+  <div style="display:none">
+  <krona collapse="true" key="true">
+   <attributes magnitude="magnitude">
+    <attribute display="Abundance (%)">magnitude</attribute>
+    <attribute display="Rank" mono="true">rank</attribute>
+    <attribute display="TaxId" mono="true">taxid</attribute>
+   </attributes>
+   <datasets>
+`)
+	for _, sample := range samples {
+		fmt.Fprintf(outfh, "    <dataset>%s</dataset>\n", html.EscapeString(sample.SampleID))
+	}
+	fmt.Fprint(outfh, "   </datasets>\n")
+
+	// the root contains the unassigned part
+	totals := make([]float64, len(samples))
+	for j := range totals {
+		var sum float64
+		for _, t := range roots {
+			sum += t.Totals[j]
+		}
+		totals[j] = math.Max(100, sum)
+	}
+	fmt.Fprintf(outfh, "   <node name=\"all\">\n    <magnitude>%s</magnitude>\n", vals(totals))
+
+	var walk func(t *camiTaxon, depth int)
+	walk = func(t *camiTaxon, depth int) {
+		indent := strings.Repeat(" ", depth+3)
+		fmt.Fprintf(outfh, "%s<node name=\"%s\">\n", indent, html.EscapeString(t.Name))
+		fmt.Fprintf(outfh, "%s <magnitude>%s</magnitude>\n", indent, vals(t.Totals))
+		fmt.Fprintf(outfh, "%s <rank>%s</rank>\n", indent, repeat(t.Rank))
+		fmt.Fprintf(outfh, "%s <taxid>%s</taxid>\n", indent, repeat(strconv.Itoa(int(t.Taxid))))
+		for _, c := range t.Children {
+			walk(c, depth+1)
+		}
+		fmt.Fprintf(outfh, "%s</node>\n", indent)
+	}
+	for _, t := range roots {
+		walk(t, 1)
+	}
+
+	fmt.Fprint(outfh, `   </node>
+  </krona>
+</div></body></html>
+`)
+}
+
+// biomRow is a row (observation) in BIOM format.
+type biomRow struct {
+	ID       string                 `json:"id"`
+	Metadata map[string]interface{} `json:"metadata"`
+}
+
+// biomColumn is a column (sample) in BIOM format.
+type biomColumn struct {
+	ID       string      `json:"id"`
+	Metadata interface{} `json:"metadata"`
+}
+
+// biomTable is the BIOM format version 1.0.
+// http://biom-format.org/documentation/format_versions/biom-1.0.html
+type biomTable struct {
+	ID                string       `json:"id"`
+	Format            string       `json:"format"`
+	FormatURL         string       `json:"format_url"`
+	Type              string       `json:"type"`
+	GeneratedBy       string       `json:"generated_by"`
+	Date              string       `json:"date"`
+	Rows              []biomRow    `json:"rows"`
+	Columns           []biomColumn `json:"columns"`
+	MatrixType        string       `json:"matrix_type"`
+	MatrixElementType string       `json:"matrix_element_type"`
+	Shape             [2]int       `json:"shape"`
+	Data              [][3]float64 `json:"data"`
+}
+
+func writeBIOM(outfh io.Writer, samples []*CAMISample, taxa []*camiTaxon) error {
+	table := biomTable{
+		ID:                "None",
+		Format:            "Biological Observation Matrix 1.0.0",
+		FormatURL:         "http://biom-format.org",
+		Type:              "Taxon table",
+		GeneratedBy:       fmt.Sprintf("TaxonKit v%s", VERSION),
+		Date:              time.Now().Format("2006-01-02T15:04:05"),
+		Rows:              make([]biomRow, 0, len(taxa)),
+		Columns:           make([]biomColumn, 0, len(samples)),
+		MatrixType:        "sparse",
+		MatrixElementType: "float",
+		Shape:             [2]int{len(taxa), len(samples)},
+		Data:              make([][3]float64, 0, len(taxa)*len(samples)),
+	}
+
+	for i, t := range taxa {
+		names, _ := t.lineage()
+		table.Rows = append(table.Rows, biomRow{
+			ID: strconv.Itoa(int(t.Taxid)),
+			Metadata: map[string]interface{}{
+				"taxonomy": names,
+				"rank":     t.Rank,
+			},
+		})
+		for j, v := range t.Abundances {
+			if v == 0 {
+				continue
+			}
+			table.Data = append(table.Data, [3]float64{float64(i), float64(j), v})
+		}
+	}
+	for _, sample := range samples {
+		table.Columns = append(table.Columns, biomColumn{ID: sample.SampleID})
+	}
+
+	data, err := json.Marshal(table)
+	if err != nil {
+		return err
+	}
+	_, err = outfh.Write(append(data, '\n'))
+	return err
+}
+
+func writeMetaPhlAnTable(outfh io.Writer, samples []*CAMISample, taxa []*camiTaxon) {
+	if len(samples) == 1 {
+		fmt.Fprintf(outfh, "#SampleID\t%s\n", samples[0].SampleID)
+		fmt.Fprintf(outfh, "#clade_name\tNCBI_tax_id\trelative_abundance\n")
+	} else {
+		ids := make([]string, 0, len(samples))
+		for _, sample := range samples {
+			ids = append(ids, sample.SampleID)
+		}
+		fmt.Fprintf(outfh, "clade_name\tNCBI_tax_id\t%s\n", strings.Join(ids, "\t"))
+	}
+
+	for _, t := range taxa {
+		names, taxids := t.lineage()
+		fmt.Fprintf(outfh, "%s\t%s", strings.Join(names, "|"), strings.Join(taxids, "|"))
+		for _, v := range t.Abundances {
+			fmt.Fprintf(outfh, "\t%s", formatAbundance(v))
+		}
+		fmt.Fprintln(outfh)
+	}
+}
+
+func init() {
+	RootCmd.AddCommand(camiConvertCmd)
+
+	addCAMIFieldFlags(camiConvertCmd)
+
+	camiConvertCmd.Flags().StringP("format", "f", "krona", "output format, available: krona, krona-html, biom, metaphlan")
+	camiConvertCmd.Flags().StringSliceP("sample-id", "s", []string{}, "only convert these samples, in the given order")
+}
@@ -34,8 +34,8 @@ import (
 // camiFilterCmd represents the fx2tab command
 var camiFilterCmd = &cobra.Command{
 	Use:   "cami-filter",
-	Short: "Remove taxa of given TaxIds and their descendants in CAMI metagenomic profile",
-	Long: `Remove taxa of given TaxIds and their descendants in CAMI metagenomic profile
+	Short: "Remove or keep taxa of given TaxIds and their descendants in CAMI metagenomic profile",
+	Long: `Remove or keep taxa of given TaxIds and their descendants in CAMI metagenomic profile
 
 Input format: 
   The CAMI (Taxonomic) Profiling Output Format    
@@ -48,16 +48,30 @@ How to:
   - A mini taxonomic tree is built from records with abundance greater than
     zero, and only leaves are retained for later use. The rank of leaves may
     be "strain", "species", or "no rank".
+  - Leaves not belonging to clades of -k/--keep-taxids are removed.
+  - Leaves with abundance below -m/--min-abundance are removed. With
+    --min-abundance-rank, the abundance of the ancestor at the rank is compared
+    instead, e.g., removing leaves of species with low abundance. The rank
+    is case-insensitive and matched with its aliases, e.g., "domain" and
+    "superkingdom". Leaves without ancestors at the rank are compared with
+    their own abundances. Abundances in the input profile are used in both
+    cases.
   - Relative abundances (in percentage) are recomputed for all leaves
     (reference genome).
   - A new taxonomic tree is built from these leaves, and abundances are 
     cumulatively added up from leaves to the root.
+  - Ranks "domain" and "acellular root" (Viruses) in profiles created with
+    NCBI taxdump files since 2025 are treated as "superkingdom" if it's in
+    --show-rank.
 
 Examples:
   1. Remove Archaea, Bacteria, and EukaryoteS, only keep Viruses:
       taxonkit cami-filter -t 2,2157,2759 test.profile -o test.filter.profile
   2. Remove Viruses:
       taxonkit cami-filter -t 10239 test.profile -o test.filter.profile
+  3. Only keep Bacteria, and remove species with abundance below 0.1%:
+      taxonkit cami-filter -k 2 -m 0.1 --min-abundance-rank species \
+          test.profile -o test.filter.profile
 
 `,
 	Run: func(cmd *cobra.Command, args []string) {
@@ -65,17 +79,12 @@ Examples:
 
 		taxidsFiles := getFlagStringSlice(cmd, "taxids-file")
 		taxidsStr := getFlagStringSlice(cmd, "taxids")
+		keepTaxidsStr := getFlagStringSlice(cmd, "keep-taxids")
+		minAbundance := getFlagNonNegativeFloat64(cmd, "min-abundance")
+		minAbundanceRank := getFlagString(cmd, "min-abundance-rank")
 
-		fieldTaxid := getFlagPositiveInt(cmd, "field-taxid") - 1
-		fieldRank := getFlagPositiveInt(cmd, "field-rank") - 1
-		fieldTaxpath := getFlagPositiveInt(cmd, "field-taxpath") - 1
-		fieldTaxpathSN := getFlagPositiveInt(cmd, "field-taxpathsn") - 1
-		fieldPercentage := getFlagPositiveInt(cmd, "field-percentage") - 1
-
-		taxidSep := getFlagString(cmd, "taxid-sep")
-		if taxidSep == "" {
-			checkError(fmt.Errorf("flag --taxid-sep needed and should not be empty"))
-		}
+		fields := getCAMIFields(cmd)
+		taxidSep := fields.TaxidSep
 
 		files := getFileList(args)
 
@@ -97,6 +106,21 @@ Examples:
 		for _i, _r := range showRanks {
 			rankOrder[_r] = _i
 		}
+		// aliases of ranks, e.g., domain -> superkingdom
+		aliases := rankAliasMap(showRanks)
+
+		// the rank and its aliases, as ranks in profiles might be either one
+		minAbundanceRank = strings.ToLower(minAbundanceRank)
+		minAbundanceRanks := make(map[string]interface{}, 4)
+		if minAbundanceRank != "" {
+			minAbundanceRanks[minAbundanceRank] = struct{}{}
+			for _, alias := range rankAliases[minAbundanceRank] {
+				minAbundanceRanks[alias] = struct{}{}
+				for _, _alias := range rankAliases[alias] {
+					minAbundanceRanks[_alias] = struct{}{}
+				}
+			}
+		}
 
 		leavesRanks := getFlagStringSlice(cmd, "leaf-ranks")
 		leavesRanksMap := make(map[string]interface{}, len(leavesRanks))
@@ -135,10 +159,22 @@ Examples:
 				checkError(fh.Close())
 			}
 		}
-		if len(taxidsStr) == 0 {
+		if len(taxidsStr) == 0 && len(keepTaxidsStr) == 0 && minAbundance == 0 {
 			log.Warningf("no taxids given")
 		} else if config.Verbose {
-			log.Infof("%d taxids loaded", len(taxidsStr))
+			if len(taxidsStr) > 0 {
+				log.Infof("%d taxids to filter out loaded", len(taxidsStr))
+			}
+			if len(keepTaxidsStr) > 0 {
+				log.Infof("only keep leaves of %d taxids: %s", len(keepTaxidsStr), strings.Join(keepTaxidsStr, ", "))
+			}
+			if minAbundance > 0 {
+				if minAbundanceRank != "" {
+					log.Infof("remove leaves with abundance of ancestors at rank %s below %v", minAbundanceRank, minAbundance)
+				} else {
+					log.Infof("remove leaves with abundance below %v", minAbundance)
+				}
+			}
 		}
 
 		filter := make(map[string]interface{}, len(taxidsStr))
@@ -146,185 +182,101 @@ Examples:
 			filter[t] = struct{}{}
 		}
 
-		// ----------------------------------------------------------------
-
-		idx := []int{fieldTaxid, fieldRank, fieldTaxpath, fieldTaxpathSN, fieldPercentage}
-
-		maxField := fieldTaxid
-		for _, i := range idx[1:] {
-			if i > maxField {
-				maxField = i
+		keep := make(map[uint32]interface{}, len(keepTaxidsStr))
+		for _, t := range keepTaxidsStr {
+			_taxid, err := strconv.Atoi(t)
+			if err != nil {
+				checkError(fmt.Errorf("invalid taxid: %s", t))
 			}
+			keep[uint32(_taxid)] = struct{}{}
 		}
-		maxField++
-
-		n := maxField + 1
 
-		file := files[0]
+		// ----------------------------------------------------------------
 
-		fh, err := xopen.Ropen(file)
+		samples, err := readCAMIProfile(files[0], fields)
 		checkError(err)
 
 		outfh, err := xopen.Wopen(config.OutFile)
 		checkError(err)
 		defer outfh.Close()
 
-		scanner := bufio.NewScanner(fh)
-
-		items := make([]string, n)
-		var _line, line string
+		var _line string
 		var _taxid int
 		var taxid uint32
 		var taxids []string
 		var taxidsUint []uint32
-		var percenage float64
-		var rank, taxpath, taxpathsn string
-		var hasData bool
-
-		var taxidS string
+		var rank, taxidS string
 		var ok bool
 		var skipThis bool
 
-		rankMap := make(map[uint32]string, 1024)
-		meta := make([]string, 0, 8)
-
-		targets := make([]*Target, 0, 512)
+		for _, sample := range samples {
+			rankMap := make(map[uint32]string, len(sample.Records))
+			abundanceMap := make(map[uint32]float64, len(sample.Records))
+			targets := make([]*Target, 0, len(sample.Records))
 
-		for scanner.Scan() {
-			line = scanner.Text()
-			if line == "" {
-				continue
-			}
-
-			// meta date
-			if line[0] == '#' || line[0] == '@' {
-				if hasData { // new record, need to summarize and output
-					targets1 := filterLeaves(rankMap, leavesRanksMap, targets)
-
-					profile := generateProfile2(targets, targets1)
-
-					nodes := make([]*ProfileNode, 0, len(profile))
-					for _, node := range profile {
-						nodes = append(nodes, node)
-					}
+			for _, r := range sample.Records {
+				taxid = r.Taxid
 
-					sort.Slice(nodes, func(i, j int) bool {
-						if rankOrder[nodes[i].Rank] < rankOrder[nodes[j].Rank] {
-							return true
-						}
-						if rankOrder[nodes[i].Rank] == rankOrder[nodes[j].Rank] {
-							return nodes[i].Abundance > nodes[j].Abundance
-						}
-						return false
-					})
-
-					for _, _line = range meta {
-						outfh.WriteString(_line + "\n")
+				rank = r.Rank
+				if _rank, ok := aliases[rank]; ok {
+					rank = _rank
+				}
+				rankMap[taxid] = rank
+				abundanceMap[taxid] = r.Abundance
+
+				skipThis = false
+				for _, taxidS = range r.TaxPath {
+					if _, ok = filter[taxidS]; ok {
+						skipThis = true
+						break
 					}
-					for _, node := range nodes {
-						if _, ok = showRanksMap[node.Rank]; !ok {
-							continue
-						}
+				}
+				if skipThis {
+					continue
+				}
 
-						taxids = taxids[:0]
-						for _, taxid = range node.LineageTaxids {
-							taxids = append(taxids, strconv.Itoa(int(taxid)))
+				taxidsUint = make([]uint32, 0, len(r.TaxPath))
+				for _, taxidS = range r.TaxPath {
+					if taxidS == "" {
+						_taxid = 0
+					} else {
+						_taxid, err = strconv.Atoi(taxidS)
+						if err != nil {
+							checkError(fmt.Errorf("failed to parse taxid: %s. taxpath: %s", taxidS, strings.Join(r.TaxPath, taxidSep)))
 						}
-
-						fmt.Fprintf(outfh, "%d\t%s\t%s\t%s\t%.15f\n",
-							node.Taxid,
-							node.Rank,
-							strings.Join(taxids, taxidSep),
-							strings.Join(node.LineageNames, taxidSep),
-							node.Abundance,
-						)
 					}
-
-					rankMap = make(map[uint32]string, 1024)
-					meta = meta[:0]
-					meta = append(meta, line)
-					targets = targets[:0]
-					hasData = false
-					continue
+					taxidsUint = append(taxidsUint, uint32(_taxid))
 				}
 
-				meta = append(meta, line)
-				continue
-			}
-
-			stringSplitN(line, "\t", n, &items)
-			if len(items) < maxField {
-				continue
-			}
+				targets = append(targets, &Target{
+					Taxid:     taxid,
+					Abundance: r.Abundance,
 
-			percenage, err = strconv.ParseFloat(items[fieldPercentage], 64)
-			if err != nil {
-				checkError(fmt.Errorf("failed to parse abundance: %s", items[fieldPercentage]))
-			}
+					Rank:          rank,
+					TaxonName:     "",
+					LineageNames:  r.TaxPathSN,
+					LineageTaxids: r.TaxPath,
 
-			if percenage == 0 {
-				continue
+					CompleteLineageTaxids: taxidsUint,
+				})
 			}
 
-			hasData = true
-
-			_taxid, err = strconv.Atoi(items[fieldTaxid])
-			if err != nil {
-				checkError(fmt.Errorf("failed to parse taxid: %s. line: %s", items[fieldTaxid], line))
-			}
-			taxid = uint32(_taxid)
-
-			rank = items[fieldRank]
-			rankMap[taxid] = rank
-			taxpath = items[fieldTaxpath]
-			taxpathsn = items[fieldTaxpathSN]
-
-			taxids = strings.Split(taxpath, taxidSep)
-			skipThis = false
-			for _, taxidS = range taxids {
-				if _, ok = filter[taxidS]; ok {
-					skipThis = true
-					break
-				}
-			}
-			if skipThis {
-				continue
-			}
-
-			taxidsUint = make([]uint32, 0, len(taxids))
-			for _, taxidS = range taxids {
-				if taxidS == "" {
-					_taxid = 0
-				} else {
-					_taxid, err = strconv.Atoi(taxidS)
-					if err != nil {
-						checkError(fmt.Errorf("failed to parse taxid: %s. taxpath: %s", taxidS, taxpath))
+			if minAbundance > 0 && len(minAbundanceRanks) > 0 {
+				found := false
+				for _, _rank := range rankMap {
+					if _, ok = minAbundanceRanks[strings.ToLower(_rank)]; ok {
+						found = true
+						break
 					}
 				}
-				taxidsUint = append(taxidsUint, uint32(_taxid))
+				if !found {
+					log.Warningf("no records of rank %s found in sample %s, abundances of leaves are compared instead",
+						minAbundanceRank, sample.SampleID)
+				}
 			}
 
-			targets = append(targets, &Target{
-				Taxid:     taxid,
-				Abundance: percenage,
-
-				Rank:          rank,
-				TaxonName:     "",
-				LineageNames:  strings.Split(taxpathsn, taxidSep),
-				LineageTaxids: taxids,
-
-				CompleteLineageTaxids: taxidsUint,
-			})
-
-		}
-
-		if err := scanner.Err(); err != nil {
-			checkError(err)
-		}
-		checkError(fh.Close())
-
-		if hasData { // new record, need to summarize and output
 			targets1 := filterLeaves(rankMap, leavesRanksMap, targets)
+			targets1 = selectLeaves(targets1, keep, minAbundance, minAbundanceRanks, rankMap, abundanceMap)
 
 			profile := generateProfile2(targets, targets1)
 
@@ -343,7 +295,7 @@ Examples:
 				return false
 			})
 
-			for _, _line = range meta {
+			for _, _line = range sample.Meta {
 				outfh.WriteString(_line + "\n")
 			}
 			for _, node := range nodes {
@@ -468,19 +420,79 @@ func filterLeaves(rankMap map[uint32]string, leavesRanksMap map[string]interface
 	return leaves
 }
 
+// selectLeaves keeps leaves belonging to given clades and with enough abundances,
+// abundances of retained leaves are recomputed.
+// Ranks in minAbundanceRanks should be in lower case.
+func selectLeaves(leaves []*Target, keep map[uint32]interface{}, minAbundance float64, minAbundanceRanks map[string]interface{},
+	rankMap map[uint32]string, abundanceMap map[uint32]float64) []*Target {
+
+	if len(keep) == 0 && minAbundance == 0 {
+		return leaves
+	}
+
+	var ok, kept bool
+	var taxid uint32
+	var abundance float64
+	leaves1 := make([]*Target, 0, len(leaves))
+	for _, target := range leaves {
+		if len(keep) > 0 {
+			kept = false
+			for _, taxid = range target.CompleteLineageTaxids {
+				if _, ok = keep[taxid]; ok {
+					kept = true
+					break
+				}
+			}
+			if !kept {
+				continue
+			}
+		}
+
+		if minAbundance > 0 {
+			abundance = abundanceMap[target.Taxid]
+			if len(minAbundanceRanks) > 0 {
+				for _, taxid = range target.CompleteLineageTaxids {
+					if taxid == 0 {
+						continue
+					}
+					if _, ok = minAbundanceRanks[strings.ToLower(rankMap[taxid])]; ok {
+						abundance = abundanceMap[taxid]
+						break
+					}
+				}
+			}
+			if abundance < minAbundance {
+				continue
+			}
+		}
+
+		leaves1 = append(leaves1, target)
+	}
+
+	// recompute abundance
+	var sum float64
+	for _, target := range leaves1 {
+		sum += target.Abundance
+	}
+	if sum > 0 {
+		for _, target := range leaves1 {
+			target.Abundance = target.Abundance / sum * 100
+		}
+	}
+
+	return leaves1
+}
+
 func init() {
 	RootCmd.AddCommand(camiFilterCmd)
 
-	camiFilterCmd.Flags().IntP("field-taxid", "", 1, "field index of taxid")
-	camiFilterCmd.Flags().IntP("field-rank", "", 2, "field index of taxid")
-	camiFilterCmd.Flags().IntP("field-taxpath", "", 3, "field index of TAXPATH")
-	camiFilterCmd.Flags().IntP("field-taxpathsn", "", 4, "field index of TAXPATHSN")
-	camiFilterCmd.Flags().IntP("field-percentage", "", 5, "field index of PERCENTAGE")
-
-	camiFilterCmd.Flags().StringP("taxid-sep", "", "|", "separator of taxid in TAXPATH and TAXPATHSN")
+	addCAMIFieldFlags(camiFilterCmd)
 
 	camiFilterCmd.Flags().StringSliceP("taxids", "t", []string{}, "the parent taxid(s) to filter out")
 	camiFilterCmd.Flags().StringSliceP("taxids-file", "f", []string{}, "file(s) for the parent taxid(s) to filter out, one taxid per line")
+	camiFilterCmd.Flags().StringSliceP("keep-taxids", "k", []string{}, "only keep leaves of the parent taxid(s)")
+	camiFilterCmd.Flags().Float64P("min-abundance", "m", 0, "remove leaves with abundance (percentage) below this value")
+	camiFilterCmd.Flags().StringP("min-abundance-rank", "", "", "compare abundances of ancestors at this rank with -m/--min-abundance, instead of leaves")
 
 	camiFilterCmd.Flags().StringSliceP("show-rank", "", []string{"superkingdom", "phylum", "class", "order", "family", "genus", "species", "strain"}, "only show TaxIds and names of these ranks")
 	camiFilterCmd.Flags().StringSliceP("leaf-ranks", "", []string{"species", "strain", "no rank"}, "only consider leaves at these ranks")
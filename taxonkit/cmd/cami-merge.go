@@ -0,0 +1,224 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// camiMergeCmd represents the cami-merge command
+var camiMergeCmd = &cobra.Command{
+	Use:   "cami-merge",
+	Short: "Merge multiple CAMI metagenomic profiles into a taxon-by-sample abundance matrix",
+	Long: `Merge multiple CAMI metagenomic profiles into a taxon-by-sample abundance matrix
+
+Input format: 
+  The CAMI (Taxonomic) Profiling Output Format    
+  - https://github.com/CAMI-challenge/contest_information/blob/master/file_formats/CAMI_TP_specification.mkd
+  - Multiple files, or one file with mutiple samples are supported.
+  - Sample IDs are read from "@SampleID", the file name is used if it's empty.
+
+Output format:
+  Tab-delimited format with a header row.
+
+  1. taxid
+  2. rank
+  3. name
+  4. lineage         (TAXPATHSN)
+  5. lineage_taxids  (TAXPATH)
+  6. abundances of samples, zeros are filled for missing taxa.
+
+How to:
+  - Taxa are aligned by TaxIds, the lineage in the first sample is used.
+  - Optionally (-u/--update-taxid), merged TaxIds are replaced with the new ones
+    according to the taxdump files, and abundances of TaxIds merged into the
+    same one are summed. Ranks of these TaxIds and names of all TaxIds in
+    lineages (TAXPATHSN) are also updated.
+  - Ranks "domain" and "acellular root" (Viruses) in profiles created with
+    NCBI taxdump files since 2025 are treated as "superkingdom" if it's in
+    -r/--show-rank.
+
+Examples:
+  1. Species matrix of all samples:
+      taxonkit cami-merge -r species *.profile -o species.tsv
+  2. Matrix of all ranks, with merged TaxIds updated:
+      taxonkit cami-merge -u *.profile -o all.tsv
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+
+		fields := getCAMIFields(cmd)
+		updateTaxid := getFlagBool(cmd, "update-taxid")
+		showRanks := getFlagStringSlice(cmd, "show-rank")
+		noHeader := getFlagBool(cmd, "no-header")
+
+		rankOrder := make(map[string]int, len(showRanks))
+		for _i, _r := range showRanks {
+			rankOrder[_r] = _i
+		}
+		// aliases of ranks, e.g., domain -> superkingdom
+		aliases := rankAliasMap(showRanks)
+
+		files := getFileList(args)
+
+		if len(files) == 1 && isStdin(files[0]) && !xopen.IsStdin() {
+			checkError(fmt.Errorf("stdin not detected"))
+		}
+
+		// ----------------------------------------------------------------
+
+		samples := make([]*CAMISample, 0, len(files))
+		ids := make(map[string]string, len(files))
+		for i, file := range files {
+			if config.Verbose {
+				log.Infof("reading CAMI profile [%d/%d]: %s", i+1, len(files), file)
+			}
+			_samples, err := readCAMIProfile(file, fields)
+			checkError(err)
+
+			for _, sample := range _samples {
+				if _file, ok := ids[sample.SampleID]; ok {
+					checkError(fmt.Errorf("duplicated sample ID: %s, in file %s and %s", sample.SampleID, _file, file))
+				}
+				ids[sample.SampleID] = file
+			}
+
+			samples = append(samples, _samples...)
+		}
+		if len(samples) == 0 {
+			log.Warningf("no samples found")
+			return
+		}
+		if config.Verbose {
+			log.Infof("%d samples loaded", len(samples))
+		}
+
+		if updateTaxid {
+			taxondb := loadTaxonomy(&config, true, true)
+
+			var taxid, newtaxid uint32
+			var status int
+			var ok bool
+			var i, _taxid int
+			var err error
+			var n int
+			for _, sample := range samples {
+				for _, r := range sample.Records {
+					taxid, status = checkTaxid(taxondb, r.Taxid)
+					if status == taxidMerged {
+						n++
+						r.Taxid = taxid
+						r.Rank = taxondb.Rank(taxid)
+					}
+
+					// TaxIds in the lineage might be merged even if the leaf is not,
+					// and names are rebuilt from the taxdump files.
+					for i = range r.TaxPath {
+						if _taxid, err = strconv.Atoi(r.TaxPath[i]); err != nil {
+							continue
+						}
+						taxid = uint32(_taxid)
+						if newtaxid, ok = taxondb.MergeNodes[taxid]; ok {
+							taxid = newtaxid
+							r.TaxPath[i] = strconv.Itoa(int(taxid))
+						}
+						if _, ok = taxondb.Nodes[taxid]; ok && i < len(r.TaxPathSN) {
+							r.TaxPathSN[i] = taxondb.Names[taxid]
+						}
+					}
+				}
+			}
+			if config.Verbose {
+				log.Infof("%d merged TaxIds updated", n)
+			}
+		}
+
+		for _, sample := range samples {
+			for _, r := range sample.Records {
+				if _rank, ok := aliases[r.Rank]; ok {
+					r.Rank = _rank
+				}
+			}
+		}
+
+		taxa, _ := newCAMITaxa(samples)
+
+		filterByRank := len(showRanks) > 0
+		if filterByRank {
+			_taxa := make([]*camiTaxon, 0, len(taxa))
+			for _, t := range taxa {
+				if _, ok := rankOrder[t.Rank]; ok {
+					_taxa = append(_taxa, t)
+				}
+			}
+			taxa = _taxa
+
+			sort.SliceStable(taxa, func(i, j int) bool {
+				return rankOrder[taxa[i].Rank] < rankOrder[taxa[j].Rank]
+			})
+		}
+
+		// ----------------------------------------------------------------
+
+		outfh, err := xopen.Wopen(config.OutFile)
+		checkError(err)
+		defer outfh.Close()
+
+		if !noHeader {
+			outfh.WriteString("taxid\trank\tname\tlineage\tlineage_taxids")
+			for _, sample := range samples {
+				outfh.WriteString("\t" + sample.SampleID)
+			}
+			outfh.WriteString("\n")
+		}
+
+		for _, t := range taxa {
+			fmt.Fprintf(outfh, "%d\t%s\t%s\t%s\t%s",
+				t.Taxid,
+				t.Rank,
+				t.Name,
+				strings.Join(t.TaxPathSN, fields.TaxidSep),
+				strings.Join(t.TaxPath, fields.TaxidSep),
+			)
+			for _, v := range t.Abundances {
+				outfh.WriteString("\t" + formatAbundance(v))
+			}
+			outfh.WriteString("\n")
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(camiMergeCmd)
+
+	addCAMIFieldFlags(camiMergeCmd)
+
+	camiMergeCmd.Flags().BoolP("update-taxid", "u", false, "replace merged TaxIds with new ones according to the taxdump files")
+	camiMergeCmd.Flags().StringSliceP("show-rank", "r", []string{}, "only output taxa of these ranks, in the given order. default: all ranks")
+	camiMergeCmd.Flags().BoolP("no-header", "H", false, "do not output the header row")
+}
@@ -0,0 +1,159 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// camiStatsCmd represents the cami-stats command
+var camiStatsCmd = &cobra.Command{
+	Use:   "cami-stats",
+	Short: "Compute alpha diversity and rank summaries of CAMI metagenomic profiles",
+	Long: `Compute alpha diversity and rank summaries of CAMI metagenomic profiles
+
+Input format: 
+  The CAMI (Taxonomic) Profiling Output Format    
+  - https://github.com/CAMI-challenge/contest_information/blob/master/file_formats/CAMI_TP_specification.mkd
+  - Multiple files, or one file with mutiple samples are supported.
+  - Sample IDs are read from "@SampleID", the file name is used if it's empty.
+
+Output format:
+  Tab-delimited format with a header row, one row for each rank of a sample.
+
+  1. sample
+  2. rank
+  3. richness    Number of taxa with abundance greater than zero.
+  4. shannon     Shannon index, -sum(p*ln(p)).
+  5. simpson     Gini-Simpson index, 1 - sum(p*p).
+  6. pielou      Pielou's evenness, shannon / ln(richness).
+  7. assigned    Sum of abundances (percentage) of the rank.
+  8. unassigned  100 - assigned.
+
+  Relative abundances (p) are normalized within each rank.
+  "NA" is outputted for undefined values, e.g., evenness of one taxon.
+
+Attention:
+  1. No extra taxonomy data needed.
+  2. Ranks "domain" and "acellular root" (Viruses) in profiles created with
+     NCBI taxdump files since 2025 are treated as "superkingdom" if it's in
+     -r/--rank.
+
+Examples:
+  taxonkit cami-stats *.profile -o stats.tsv
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+
+		fields := getCAMIFields(cmd)
+		ranks := getFlagStringSlice(cmd, "rank")
+		if len(ranks) == 0 {
+			checkError(fmt.Errorf("flag -r/--rank needed"))
+		}
+		// aliases of ranks, e.g., domain -> superkingdom
+		aliases := rankAliasMap(ranks)
+
+		files := getFileList(args)
+
+		if len(files) == 1 && isStdin(files[0]) && !xopen.IsStdin() {
+			checkError(fmt.Errorf("stdin not detected"))
+		}
+
+		outfh, err := xopen.Wopen(config.OutFile)
+		checkError(err)
+		defer outfh.Close()
+
+		outfh.WriteString("sample\trank\trichness\tshannon\tsimpson\tpielou\tassigned\tunassigned\n")
+
+		formatValue := func(v float64) string {
+			if math.IsNaN(v) {
+				return "NA"
+			}
+			return strconv.FormatFloat(v, 'f', 6, 64)
+		}
+
+		var shannon, simpson, pielou, sum, p float64
+		for _, file := range files {
+			samples, err := readCAMIProfile(file, fields)
+			checkError(err)
+
+			for _, sample := range samples {
+				for _, r := range sample.Records {
+					if _rank, ok := aliases[r.Rank]; ok {
+						r.Rank = _rank
+					}
+				}
+				rankAbundances := camiRankAbundances(sample)
+
+				for _, rank := range ranks {
+					abundances := rankAbundances[rank]
+
+					sum = 0
+					for _, v := range abundances {
+						sum += v
+					}
+
+					shannon, simpson, pielou = math.NaN(), math.NaN(), math.NaN()
+					if sum > 0 {
+						shannon, simpson = 0, 1
+						for _, v := range abundances {
+							p = v / sum
+							shannon -= p * math.Log(p)
+							simpson -= p * p
+						}
+						if len(abundances) > 1 {
+							pielou = shannon / math.Log(float64(len(abundances)))
+						}
+					}
+
+					fmt.Fprintf(outfh, "%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\n",
+						sample.SampleID,
+						rank,
+						len(abundances),
+						formatValue(shannon),
+						formatValue(simpson),
+						formatValue(pielou),
+						formatValue(sum),
+						formatValue(math.Max(100-sum, 0)),
+					)
+				}
+
+				if config.LineBuffered {
+					outfh.Flush()
+				}
+			}
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(camiStatsCmd)
+
+	addCAMIFieldFlags(camiStatsCmd)
+
+	camiStatsCmd.Flags().StringSliceP("rank", "r", []string{"superkingdom", "phylum", "class", "order", "family", "genus", "species", "strain"}, "ranks to summarize")
+}
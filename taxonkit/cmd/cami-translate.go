@@ -0,0 +1,399 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// camiTranslateCmd represents the cami-translate command
+var camiTranslateCmd = &cobra.Command{
+	Use:   "cami-translate",
+	Short: "Translate CAMI metagenomic profiles between taxonomies, e.g., NCBI and GTDB",
+	Long: `Translate CAMI metagenomic profiles between taxonomies, e.g., NCBI and GTDB
+
+Input format: 
+  The CAMI (Taxonomic) Profiling Output Format    
+  - https://github.com/CAMI-challenge/contest_information/blob/master/file_formats/CAMI_TP_specification.mkd
+  - One file with mutiple samples is also supported.
+
+Taxonomy data:
+  - Source taxonomy (the one used in the profile): --from-data-dir
+  - Target taxonomy: --data-dir, or the environment variable TAXONKIT_DB.
+
+Mapping files:
+  Taxa of the two taxonomies are linked via genome/assembly accessions.
+  Two tab-delimited files are needed:
+    --from-map  accessions and TaxIds of the source taxonomy,
+                e.g., GTDB metadata file with the "ncbi_taxid" column.
+    --to-map    accessions and TaxIds of the target taxonomy,
+                e.g., taxid.map created by "taxonkit create-taxdump".
+  Columns are specified by --from-acc-field/--from-taxid-field and
+  --to-acc-field/--to-taxid-field. Multiple TaxIds separated by commas
+  are supported. Lines with non-numeric TaxIds (e.g., header lines) are
+  skipped. Prefixes "RS_" and "GB_" of GTDB accessions are removed.
+
+How to:
+  - A mini taxonomic tree is built from records with abundance greater than
+    zero, and only leaves are retained for later use, like "cami-filter".
+  - For each leaf, accessions with source TaxIds belonging to the clade of the
+    leaf are collected, and the abundance is split among the target TaxIds of
+    these accessions, proportional to the numbers of accessions.
+  - Leaves without any accessions are unmapped. The abundances are reported
+    in the log and -u/--unmapped-file, and abundances of mapped taxa can
+    be optionally recomputed with the flag -R/--recompute-abd.
+  - A new taxonomic tree is built with the target taxonomy, like
+    "taxonkit profile2cami".
+
+Examples:
+  # NCBI -> GTDB
+  taxonkit cami-translate --from-data-dir ~/.taxonkit \
+      --from-map bac120_metadata.tsv --from-taxid-field 77 \
+      --to-map gtdb-taxdump/R220/taxid.map \
+      --data-dir gtdb-taxdump/R220 -t GTDB-R220 \
+      ncbi.profile -o gtdb.profile
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+
+		fields := getCAMIFields(cmd)
+		fromDataDir := getFlagString(cmd, "from-data-dir")
+		if fromDataDir == "" {
+			checkError(fmt.Errorf("flag --from-data-dir needed"))
+		}
+		fromMap := getFlagString(cmd, "from-map")
+		toMap := getFlagString(cmd, "to-map")
+		if fromMap == "" || toMap == "" {
+			checkError(fmt.Errorf("flags --from-map and --to-map needed"))
+		}
+		fromAccField := getFlagPositiveInt(cmd, "from-acc-field") - 1
+		fromTaxidField := getFlagPositiveInt(cmd, "from-taxid-field") - 1
+		toAccField := getFlagPositiveInt(cmd, "to-acc-field") - 1
+		toTaxidField := getFlagPositiveInt(cmd, "to-taxid-field") - 1
+		unmappedFile := getFlagString(cmd, "unmapped-file")
+
+		taxonomyID := getFlagString(cmd, "taxonomy-id")
+		recomputeAbd := getFlagBool(cmd, "recompute-abd")
+		showRanks := getFlagStringSlice(cmd, "show-rank")
+
+		leavesRanks := getFlagStringSlice(cmd, "leaf-ranks")
+		leavesRanksMap := make(map[string]interface{}, len(leavesRanks))
+		for _, r := range leavesRanks {
+			leavesRanksMap[r] = struct{}{}
+		}
+
+		files := getFileList(args)
+
+		if len(files) > 1 {
+			checkError(fmt.Errorf("only one input file allowed"))
+		}
+
+		if len(files) == 1 && isStdin(files[0]) && !xopen.IsStdin() {
+			checkError(fmt.Errorf("stdin not detected"))
+		}
+
+		// ----------------------------------------------------------------
+
+		// ranks are outputted from high to low
+		dbRankOrder, _, err := readRankOrder(config, "")
+		checkError(err)
+		sortRanksByOrder(showRanks, dbRankOrder)
+
+		showRanksMap := make(map[string]interface{}, 128)
+		for _, _rank := range showRanks {
+			showRanksMap[_rank] = struct{}{}
+		}
+		rankOrder := make(map[string]int, len(showRanks))
+		for _i, _r := range showRanks {
+			rankOrder[_r] = _i
+		}
+		// aliases of ranks, e.g., domain -> superkingdom
+		aliases := rankAliasMap(showRanks)
+		for alias, _r := range aliases {
+			showRanksMap[alias] = struct{}{}
+			rankOrder[alias] = rankOrder[_r]
+		}
+
+		samples, err := readCAMIProfile(files[0], fields)
+		checkError(err)
+		if config.Verbose {
+			log.Infof("%d samples loaded", len(samples))
+		}
+
+		acc2from, err := readAccession2Taxids(fromMap, fromAccField, fromTaxidField)
+		checkError(err)
+		acc2to, err := readAccession2Taxids(toMap, toAccField, toTaxidField)
+		checkError(err)
+
+		// source TaxId -> target TaxIds, with the number of accessions
+		links := make(map[uint32]map[uint32]int, len(acc2from))
+		var m map[uint32]int
+		var tos []uint32
+		var ok bool
+		var nLinked int
+		for acc, froms := range acc2from {
+			if tos, ok = acc2to[acc]; !ok {
+				continue
+			}
+			nLinked++
+			for _, from := range froms {
+				if m, ok = links[from]; !ok {
+					m = make(map[uint32]int, len(tos))
+					links[from] = m
+				}
+				for _, to := range tos {
+					m[to]++
+				}
+			}
+		}
+		if config.Verbose {
+			log.Infof("%d accessions in both mapping files", nLinked)
+		}
+		if nLinked == 0 {
+			log.Warningf("no accessions shared by the two mapping files: %s, %s", fromMap, toMap)
+		}
+
+		configFrom := config
+		configFrom.DataDir = fromDataDir
+		fromdb := loadTaxonomy(&configFrom, false, false)
+
+		todb := loadTaxonomy(&config, true, true)
+
+		// ----------------------------------------------------------------
+
+		outfh, err := xopen.Wopen(config.OutFile)
+		checkError(err)
+		defer outfh.Close()
+
+		var outfhUnmapped *xopen.Writer
+		if unmappedFile != "" {
+			outfhUnmapped, err = xopen.Wopen(unmappedFile)
+			checkError(err)
+			defer outfhUnmapped.Close()
+
+			outfhUnmapped.WriteString("sample\ttaxid\trank\tname\tabundance\n")
+		}
+
+		var _taxid int
+		var taxid uint32
+		for _, sample := range samples {
+			// leaves in the source taxonomy
+			rankMap := make(map[uint32]string, len(sample.Records))
+			targets := make([]*Target, 0, len(sample.Records))
+			for _, r := range sample.Records {
+				rankMap[r.Taxid] = r.Rank
+
+				taxidsUint := make([]uint32, 0, len(r.TaxPath))
+				for _, taxidS := range r.TaxPath {
+					if taxidS == "" {
+						_taxid = 0
+					} else {
+						_taxid, err = strconv.Atoi(taxidS)
+						if err != nil {
+							checkError(fmt.Errorf("failed to parse taxid: %s. taxpath: %s", taxidS, r.TaxPath))
+						}
+					}
+					taxidsUint = append(taxidsUint, uint32(_taxid))
+				}
+
+				targets = append(targets, &Target{
+					Taxid:     r.Taxid,
+					Abundance: r.Abundance,
+
+					Rank:          r.Rank,
+					LineageNames:  r.TaxPathSN,
+					LineageTaxids: r.TaxPath,
+
+					CompleteLineageTaxids: taxidsUint,
+				})
+			}
+
+			leaves := filterLeaves(rankMap, leavesRanksMap, targets)
+
+			// leaf -> target TaxIds
+			leaf2tos := make(map[uint32]map[uint32]int, len(leaves))
+			for _, leaf := range leaves {
+				if taxid, ok = fromdb.TaxId(leaf.Taxid); !ok {
+					continue
+				}
+				leaf2tos[taxid] = make(map[uint32]int, 8)
+			}
+			for from, tos := range links {
+				for _, taxid = range fromdb.LineageTaxIds(from) {
+					if m, ok = leaf2tos[taxid]; ok {
+						for to, n := range tos {
+							m[to] += n
+						}
+						break
+					}
+				}
+			}
+
+			// leaves in the target taxonomy
+			targets = make([]*Target, 0, len(leaves))
+			var sum, unmapped float64
+			var nUnmapped int
+			var total int
+			for _, leaf := range leaves {
+				m = nil
+				if taxid, ok = fromdb.TaxId(leaf.Taxid); ok {
+					m = leaf2tos[taxid]
+				}
+
+				total = 0
+				for _, n := range m {
+					total += n
+				}
+				if total == 0 {
+					nUnmapped++
+					unmapped += leaf.Abundance
+					if outfhUnmapped != nil {
+						fmt.Fprintf(outfhUnmapped, "%s\t%d\t%s\t%s\t%.15f\n",
+							sample.SampleID, leaf.Taxid, leaf.Rank, leaf.LineageNames[len(leaf.LineageNames)-1], leaf.Abundance)
+					}
+					continue
+				}
+
+				for _, to := range sortedKeys(m) {
+					target := &Target{Taxid: to, Abundance: leaf.Abundance * float64(m[to]) / float64(total)}
+					if !target.AddTaxonomy(todb, showRanksMap, to) {
+						log.Warningf("[%s] taxid is not found in the target taxonomy: %d", sample.SampleID, to)
+						unmapped += target.Abundance
+						continue
+					}
+					targets = append(targets, target)
+					sum += target.Abundance
+				}
+			}
+			if nUnmapped > 0 {
+				log.Warningf("[%s] %d of %d leaves unmapped, abundance: %f", sample.SampleID, nUnmapped, len(leaves), unmapped)
+			} else if config.Verbose {
+				log.Infof("[%s] all %d leaves mapped", sample.SampleID, len(leaves))
+			}
+
+			if unmapped > 0 && recomputeAbd && sum > 0 {
+				for _, target := range targets {
+					target.Abundance = target.Abundance / sum * 100
+				}
+			}
+
+			profile := generateProfile(todb, targets)
+
+			nodes := make([]*ProfileNode, 0, len(profile))
+			for _, node := range profile {
+				nodes = append(nodes, node)
+			}
+
+			sortProfileNodes(nodes, rankOrder)
+
+			writeCAMIProfile(outfh, todb, sample.SampleID, taxonomyID, showRanks, aliases, nodes, 1)
+		}
+	},
+}
+
+// readAccession2Taxids reads accessions and TaxIds from a tab-delimited file,
+// multiple TaxIds can be separated by commas.
+func readAccession2Taxids(file string, accField, taxidField int) (map[string][]uint32, error) {
+	fh, err := xopen.Ropen(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	maxField := accField
+	if taxidField > maxField {
+		maxField = taxidField
+	}
+	n := maxField + 2
+	items := make([]string, n)
+
+	m := make(map[string][]uint32, 1<<16)
+
+	scanner := bufio.NewScanner(fh)
+	var acc string
+	var _taxid int
+	var taxids []uint32
+	for scanner.Scan() {
+		stringSplitN(strings.TrimRight(scanner.Text(), "\r\n"), "\t", n, &items)
+		if len(items) <= maxField {
+			continue
+		}
+
+		taxids = taxids[:0]
+		for _, s := range strings.Split(items[taxidField], ",") {
+			if _taxid, err = strconv.Atoi(strings.TrimSpace(s)); err != nil {
+				continue
+			}
+			taxids = append(taxids, uint32(_taxid))
+		}
+		if len(taxids) == 0 {
+			continue
+		}
+
+		acc = items[accField]
+		if strings.HasPrefix(acc, "RS_") || strings.HasPrefix(acc, "GB_") {
+			acc = acc[3:]
+		}
+		m[acc] = append(m[acc], taxids...)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func sortedKeys(m map[uint32]int) []uint32 {
+	keys := make([]uint32, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+func init() {
+	RootCmd.AddCommand(camiTranslateCmd)
+
+	addCAMIFieldFlags(camiTranslateCmd)
+
+	camiTranslateCmd.Flags().StringP("from-data-dir", "", "", "directory containing taxdump files of the source taxonomy")
+	camiTranslateCmd.Flags().StringP("from-map", "", "", "tab-delimited file mapping accessions to TaxIds of the source taxonomy")
+	camiTranslateCmd.Flags().IntP("from-acc-field", "", 1, "field index of accession in --from-map")
+	camiTranslateCmd.Flags().IntP("from-taxid-field", "", 2, "field index of TaxId in --from-map")
+	camiTranslateCmd.Flags().StringP("to-map", "", "", "tab-delimited file mapping accessions to TaxIds of the target taxonomy")
+	camiTranslateCmd.Flags().IntP("to-acc-field", "", 1, "field index of accession in --to-map")
+	camiTranslateCmd.Flags().IntP("to-taxid-field", "", 2, "field index of TaxId in --to-map")
+	camiTranslateCmd.Flags().StringP("unmapped-file", "u", "", "file for saving unmapped leaves, with abundances")
+
+	camiTranslateCmd.Flags().StringP("taxonomy-id", "t", "", `taxonomy ID in result file`)
+	camiTranslateCmd.Flags().StringSliceP("show-rank", "r", []string{"superkingdom", "phylum", "class", "order", "family", "genus", "species", "strain"}, "only show TaxIds and names of these ranks")
+	camiTranslateCmd.Flags().StringSliceP("leaf-ranks", "", []string{"species", "strain", "no rank"}, "only consider leaves at these ranks")
+	camiTranslateCmd.Flags().BoolP("recompute-abd", "R", false, "recompute abundance if some leaves are unmapped")
+}
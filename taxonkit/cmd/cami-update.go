@@ -0,0 +1,215 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// camiUpdateCmd represents the cami-update command
+var camiUpdateCmd = &cobra.Command{
+	Use:   "cami-update",
+	Short: "Update TaxIds and lineages in CAMI metagenomic profiles with current taxonomy",
+	Long: `Update TaxIds and lineages in CAMI metagenomic profiles with current taxonomy
+
+Input format: 
+  The CAMI (Taxonomic) Profiling Output Format    
+  - https://github.com/CAMI-challenge/contest_information/blob/master/file_formats/CAMI_TP_specification.mkd
+  - One file with mutiple samples is also supported.
+
+How to:
+  - A mini taxonomic tree is built from records with abundance greater than
+    zero, and only leaves are retained for later use, like "cami-filter".
+    The rank of leaves may be "strain", "species", or "no rank".
+  - TaxIds of leaves are checked with current taxonomy:
+    - Merged TaxIds are replaced with new ones, and the abundances are summed up.
+    - Deleted or unfound TaxIds are removed, the abundances of other leaves
+      can be optionally recomputed with the flag -R/--recompute-abd.
+  - A new taxonomic tree is built from these leaves with current taxonomy,
+    and abundances are cumulatively added up from leaves to the root.
+    TAXPATH and TAXPATHSN are rebuilt, and the "@TaxonomyID" header is
+    replaced with -t/--taxonomy-id.
+  - Ranks "domain" and "acellular root" (Viruses) in NCBI taxdump files
+    since 2025 are outputted as "superkingdom" if it's in -r/--show-rank.
+
+Examples:
+  taxonkit cami-update -t 2025-01-01 old.profile -o new.profile
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+
+		fields := getCAMIFields(cmd)
+		taxonomyID := getFlagString(cmd, "taxonomy-id")
+		recomputeAbd := getFlagBool(cmd, "recompute-abd")
+		showRanks := getFlagStringSlice(cmd, "show-rank")
+
+		leavesRanks := getFlagStringSlice(cmd, "leaf-ranks")
+		leavesRanksMap := make(map[string]interface{}, len(leavesRanks))
+		for _, r := range leavesRanks {
+			leavesRanksMap[r] = struct{}{}
+		}
+
+		if taxonomyID == "" {
+			log.Warningf("flag -t/--taxonomy-id is recommended for the new taxonomy version")
+		}
+
+		files := getFileList(args)
+
+		if len(files) > 1 {
+			checkError(fmt.Errorf("only one input file allowed"))
+		}
+
+		if len(files) == 1 && isStdin(files[0]) && !xopen.IsStdin() {
+			checkError(fmt.Errorf("stdin not detected"))
+		}
+
+		// ----------------------------------------------------------------
+
+		// ranks are outputted from high to low
+		dbRankOrder, _, err := readRankOrder(config, "")
+		checkError(err)
+		sortRanksByOrder(showRanks, dbRankOrder)
+
+		showRanksMap := make(map[string]interface{}, 128)
+		for _, _rank := range showRanks {
+			showRanksMap[_rank] = struct{}{}
+		}
+		rankOrder := make(map[string]int, len(showRanks))
+		for _i, _r := range showRanks {
+			rankOrder[_r] = _i
+		}
+		// aliases of ranks, e.g., domain -> superkingdom
+		aliases := rankAliasMap(showRanks)
+		for alias, _r := range aliases {
+			showRanksMap[alias] = struct{}{}
+			rankOrder[alias] = rankOrder[_r]
+		}
+
+		samples, err := readCAMIProfile(files[0], fields)
+		checkError(err)
+		if config.Verbose {
+			log.Infof("%d samples loaded", len(samples))
+		}
+
+		taxdb := loadTaxonomy(&config, true, true)
+
+		outfh, err := xopen.Wopen(config.OutFile)
+		checkError(err)
+		defer outfh.Close()
+
+		var _taxid int
+		for _, sample := range samples {
+			// leaves in the original taxonomy
+			rankMap := make(map[uint32]string, len(sample.Records))
+			targets := make([]*Target, 0, len(sample.Records))
+			for _, r := range sample.Records {
+				rankMap[r.Taxid] = r.Rank
+
+				taxidsUint := make([]uint32, 0, len(r.TaxPath))
+				for _, taxidS := range r.TaxPath {
+					if taxidS == "" {
+						_taxid = 0
+					} else {
+						_taxid, err = strconv.Atoi(taxidS)
+						if err != nil {
+							checkError(fmt.Errorf("failed to parse taxid: %s. taxpath: %s", taxidS, r.TaxPath))
+						}
+					}
+					taxidsUint = append(taxidsUint, uint32(_taxid))
+				}
+
+				targets = append(targets, &Target{
+					Taxid:     r.Taxid,
+					Abundance: r.Abundance,
+
+					Rank:          r.Rank,
+					LineageNames:  r.TaxPathSN,
+					LineageTaxids: r.TaxPath,
+
+					CompleteLineageTaxids: taxidsUint,
+				})
+			}
+
+			leaves := filterLeaves(rankMap, leavesRanksMap, targets)
+
+			// leaves in current taxonomy
+			targets = make([]*Target, 0, len(leaves))
+			var nMerged, nDeleted int
+			var sum float64
+			for _, leaf := range leaves {
+				target := &Target{Taxid: leaf.Taxid, Abundance: leaf.Abundance}
+				if !target.AddTaxonomy(taxdb, showRanksMap, leaf.Taxid) {
+					log.Warningf("[%s] taxid is deleted in current taxonomy version: %d", sample.SampleID, leaf.Taxid)
+					nDeleted++
+					continue
+				}
+				if target.Taxid != leaf.Taxid {
+					if config.Verbose {
+						log.Infof("[%s] taxid %d was merged into %d", sample.SampleID, leaf.Taxid, target.Taxid)
+					}
+					nMerged++
+				}
+				targets = append(targets, target)
+				sum += target.Abundance
+			}
+			if config.Verbose {
+				log.Infof("[%s] %d leaves, %d merged, %d deleted", sample.SampleID, len(leaves), nMerged, nDeleted)
+			}
+
+			if nDeleted > 0 {
+				if recomputeAbd {
+					for _, target := range targets {
+						target.Abundance = target.Abundance / sum * 100
+					}
+				} else {
+					log.Warningf("[%s] you may recomputed abundance with the flag -R/--recompute-abd", sample.SampleID)
+				}
+			}
+
+			profile := generateProfile(taxdb, targets)
+
+			nodes := make([]*ProfileNode, 0, len(profile))
+			for _, node := range profile {
+				nodes = append(nodes, node)
+			}
+
+			sortProfileNodes(nodes, rankOrder)
+
+			writeCAMIProfile(outfh, taxdb, sample.SampleID, taxonomyID, showRanks, aliases, nodes, 1)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(camiUpdateCmd)
+
+	addCAMIFieldFlags(camiUpdateCmd)
+
+	camiUpdateCmd.Flags().StringP("taxonomy-id", "t", "", `taxonomy ID in result file`)
+	camiUpdateCmd.Flags().StringSliceP("show-rank", "r", []string{"superkingdom", "phylum", "class", "order", "family", "genus", "species", "strain"}, "only show TaxIds and names of these ranks")
+	camiUpdateCmd.Flags().StringSliceP("leaf-ranks", "", []string{"species", "strain", "no rank"}, "only consider leaves at these ranks")
+	camiUpdateCmd.Flags().BoolP("recompute-abd", "R", false, "recompute abundance if some TaxIds are deleted in current taxonomy version")
+}
@@ -0,0 +1,439 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/shenwei356/bio/taxdump"
+	"github.com/shenwei356/util/pathutil"
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// camiValidateCmd represents the cami-validate command
+var camiValidateCmd = &cobra.Command{
+	Use:   "cami-validate",
+	Short: "Validate CAMI metagenomic profiles",
+	Long: `Validate CAMI metagenomic profiles
+
+Input format: 
+  The CAMI (Taxonomic) Profiling Output Format    
+  - https://github.com/CAMI-challenge/contest_information/blob/master/file_formats/CAMI_TP_specification.mkd
+  - Multiple files, or one file with mutiple samples are supported.
+
+Checks:
+  Errors:
+    - Missing headers: "@SampleID", "@Version", "@Ranks", and the column
+      header "@@TAXID", which should contain TAXID, RANK, TAXPATH, TAXPATHSN
+      and PERCENTAGE. Columns are located by the column header.
+    - Data lines with fewer columns, invalid TaxIds or percentages
+      (out of the range of [0, 100]), or duplicated TaxIds in a sample.
+    - Ranks not in "@Ranks".
+    - Different numbers of items in TAXPATH and TAXPATHSN, the last item of
+      TAXPATH differs from TAXID, or the number of items in TAXPATH is greater
+      than the position of the rank in "@Ranks". Missing ranks can be empty
+      or omitted in TAXPATH.
+    - Sums of percentages greater than 100 for a rank in a sample.
+    - TaxIds in TAXPATH not being ancestors of TAXID in the taxdump.
+  Warnings (cross-checked with the taxdump files, if available):
+    - TaxIds not found, deleted, or merged into other TaxIds.
+    - Ranks or names different from these in the taxdump.
+  
+  Cross-checking with taxdump files is skipped if they are not available or
+  the flag -n/--no-taxdump is given.
+
+Output format:
+  Tab-delimited format with a header row: file, line, level, message.
+  The exit status is non-zero if any error is found.
+
+Examples:
+  taxonkit cami-validate test.profile
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+
+		taxidSep := getFlagString(cmd, "taxid-sep")
+		if taxidSep == "" {
+			checkError(fmt.Errorf("flag --taxid-sep needed and should not be empty"))
+		}
+		noTaxdump := getFlagBool(cmd, "no-taxdump")
+		tolerance := getFlagNonNegativeFloat64(cmd, "tolerance")
+
+		files := getFileList(args)
+
+		if len(files) == 1 && isStdin(files[0]) && !xopen.IsStdin() {
+			checkError(fmt.Errorf("stdin not detected"))
+		}
+
+		// ----------------------------------------------------------------
+
+		var taxondb *taxdump.Taxonomy
+		if !noTaxdump {
+			existed, err := pathutil.Exists(filepath.Join(config.DataDir, "nodes.dmp"))
+			checkError(err)
+			if existed {
+				taxondb = loadTaxonomy(&config, true, true)
+			} else {
+				log.Warningf("taxdump files not found in %s, cross-checking with the taxonomy skipped", config.DataDir)
+			}
+		}
+
+		outfh, err := xopen.Wopen(config.OutFile)
+		checkError(err)
+
+		outfh.WriteString("file\tline\tlevel\tmessage\n")
+
+		var nErrors, nWarnings int
+		for _, file := range files {
+			v := newCAMIValidator(file, taxidSep, tolerance, taxondb)
+			checkError(v.validate(func(m camiMessage) {
+				if m.isError {
+					nErrors++
+					fmt.Fprintf(outfh, "%s\t%d\terror\t%s\n", file, m.line, m.message)
+				} else {
+					nWarnings++
+					fmt.Fprintf(outfh, "%s\t%d\twarning\t%s\n", file, m.line, m.message)
+				}
+				if config.LineBuffered {
+					outfh.Flush()
+				}
+			}))
+		}
+		checkError(outfh.Close()) // closed before exiting with errors
+
+		if nErrors > 0 {
+			checkError(fmt.Errorf("%d errors and %d warnings found", nErrors, nWarnings))
+		}
+		if nWarnings > 0 {
+			log.Warningf("%d warnings found", nWarnings)
+		} else if config.Verbose {
+			log.Infof("no errors found")
+		}
+	},
+}
+
+// camiMessage is an error or a warning of a line.
+type camiMessage struct {
+	line    int
+	isError bool
+	message string
+}
+
+// camiValidator checks a CAMI profile line by line.
+type camiValidator struct {
+	file      string
+	taxidSep  string
+	tolerance float64
+	taxondb   *taxdump.Taxonomy
+
+	report func(camiMessage)
+
+	// states of the current sample
+	sampleLine int // the first line of the sample
+	headers    map[string]int
+	ranks      map[string]int
+	columns    map[string]int
+	taxids     map[uint32]int
+	rankList   []string // ranks in the order of appearance
+	rankSums   map[string]float64
+	rankLines  map[string]int
+	hasData    bool
+}
+
+func newCAMIValidator(file, taxidSep string, tolerance float64, taxondb *taxdump.Taxonomy) *camiValidator {
+	return &camiValidator{
+		file:      file,
+		taxidSep:  taxidSep,
+		tolerance: tolerance,
+		taxondb:   taxondb,
+	}
+}
+
+func (v *camiValidator) errorf(line int, format string, a ...interface{}) {
+	v.report(camiMessage{line: line, isError: true, message: fmt.Sprintf(format, a...)})
+}
+
+func (v *camiValidator) warningf(line int, format string, a ...interface{}) {
+	v.report(camiMessage{line: line, isError: false, message: fmt.Sprintf(format, a...)})
+}
+
+func (v *camiValidator) resetSample(line int) {
+	v.sampleLine = line
+	v.headers = make(map[string]int, 8)
+	v.ranks = nil
+	v.columns = nil
+	v.taxids = make(map[uint32]int, 1024)
+	v.rankList = v.rankList[:0]
+	v.rankSums = make(map[string]float64, 8)
+	v.rankLines = make(map[string]int, 8)
+	v.hasData = false
+}
+
+// checkSample checks headers and sums of percentages of the current sample.
+func (v *camiValidator) checkSample() {
+	for _, key := range []string{"SAMPLEID", "VERSION", "RANKS"} {
+		if _, ok := v.headers[key]; !ok {
+			v.errorf(v.sampleLine, "missing header: @%s", camiHeaderNames[key])
+		}
+	}
+	if v.columns == nil {
+		v.errorf(v.sampleLine, "missing column header: @@TAXID")
+	}
+	var sum float64
+	for _, rank := range v.rankList {
+		if sum = v.rankSums[rank]; sum > 100+v.tolerance {
+			v.errorf(v.rankLines[rank], "sum of percentages of rank %s is greater than 100: %f", rank, sum)
+		}
+	}
+}
+
+var camiHeaderNames = map[string]string{"SAMPLEID": "SampleID", "VERSION": "Version", "RANKS": "Ranks"}
+
+var camiColumns = []string{"TAXID", "RANK", "TAXPATH", "TAXPATHSN", "PERCENTAGE"}
+
+func (v *camiValidator) validate(report func(camiMessage)) error {
+	v.report = report
+
+	v.resetSample(1)
+
+	fh, err := xopen.Ropen(v.file)
+	if err == xopen.ErrNoContent {
+		v.checkSample()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	scanner := bufio.NewScanner(fh)
+	buf := make([]byte, 0, 1<<20)
+	scanner.Buffer(buf, 1<<26)
+
+	var line, key, value string
+	var lineNo, i int
+	for scanner.Scan() {
+		lineNo++
+		line = strings.TrimRight(scanner.Text(), "\r\n")
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		// column header
+		if strings.HasPrefix(line, "@@") {
+			if v.hasData {
+				v.checkSample()
+				v.resetSample(lineNo)
+			}
+
+			v.columns = make(map[string]int, 5)
+			for i, key = range strings.Split(line[2:], "\t") {
+				v.columns[strings.ToUpper(strings.TrimSpace(key))] = i
+			}
+			for _, key = range camiColumns {
+				if _, ok := v.columns[key]; !ok {
+					v.errorf(lineNo, "column %s missing in the column header", key)
+				}
+			}
+			continue
+		}
+
+		// headers
+		if line[0] == '@' {
+			if v.hasData {
+				v.checkSample()
+				v.resetSample(lineNo)
+			}
+
+			i = strings.Index(line, ":")
+			if i < 0 {
+				v.errorf(lineNo, "invalid header, no colon found: %s", line)
+				continue
+			}
+			key, value = strings.ToUpper(strings.TrimSpace(line[1:i])), strings.TrimSpace(line[i+1:])
+			if _, ok := v.headers[key]; ok {
+				v.errorf(lineNo, "duplicated header: %s", line[:i])
+			}
+			v.headers[key] = lineNo
+
+			switch key {
+			case "SAMPLEID":
+				if value == "" {
+					v.warningf(lineNo, "empty sample ID")
+				}
+			case "RANKS":
+				v.ranks = make(map[string]int, 8)
+				for i, key = range strings.Split(value, v.taxidSep) {
+					v.ranks[key] = i
+				}
+			}
+			continue
+		}
+
+		v.hasData = true
+		if v.columns == nil { // reported in checkSample()
+			continue
+		}
+
+		v.validateRecord(lineNo, line)
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+
+	// the last sample, or no samples in an empty file
+	v.checkSample()
+	return nil
+}
+
+func (v *camiValidator) validateRecord(lineNo int, line string) {
+	items := strings.Split(line, "\t")
+	for _, key := range camiColumns {
+		if i, ok := v.columns[key]; ok && i >= len(items) {
+			v.errorf(lineNo, "column %s missing, only %d columns found", key, len(items))
+			return
+		}
+	}
+	column := func(key string) string {
+		if i, ok := v.columns[key]; ok {
+			return items[i]
+		}
+		return ""
+	}
+
+	// taxid
+	_taxid, err := strconv.Atoi(column("TAXID"))
+	if err != nil || _taxid <= 0 {
+		v.errorf(lineNo, "invalid TaxId: %s", column("TAXID"))
+		return
+	}
+	taxid := uint32(_taxid)
+	if _line, ok := v.taxids[taxid]; ok {
+		v.errorf(lineNo, "duplicated TaxId %d, first seen in line %d", taxid, _line)
+	} else {
+		v.taxids[taxid] = lineNo
+	}
+
+	// percentage
+	rank := column("RANK")
+	percentage, err := strconv.ParseFloat(column("PERCENTAGE"), 64)
+	if err != nil {
+		v.errorf(lineNo, "invalid percentage: %s", column("PERCENTAGE"))
+	} else if percentage < 0 || percentage > 100 {
+		v.errorf(lineNo, "percentage out of range [0, 100]: %s", column("PERCENTAGE"))
+	} else {
+		if _, ok := v.rankSums[rank]; !ok {
+			v.rankList = append(v.rankList, rank)
+		}
+		v.rankSums[rank] += percentage
+		v.rankLines[rank] = lineNo
+	}
+
+	// taxpath
+	taxpath := strings.Split(column("TAXPATH"), v.taxidSep)
+	taxpathsn := strings.Split(column("TAXPATHSN"), v.taxidSep)
+	if len(taxpath) != len(taxpathsn) {
+		v.errorf(lineNo, "numbers of items in TAXPATH (%d) and TAXPATHSN (%d) are different", len(taxpath), len(taxpathsn))
+	}
+	if taxpath[len(taxpath)-1] != column("TAXID") {
+		v.errorf(lineNo, "the last item of TAXPATH (%s) differs from TAXID (%s)", taxpath[len(taxpath)-1], column("TAXID"))
+	}
+
+	// rank
+	if v.ranks != nil {
+		if i, ok := v.ranks[rank]; !ok {
+			v.errorf(lineNo, "rank not in @Ranks: %s", rank)
+		} else if len(taxpath) > i+1 {
+			// missing ranks might be omitted, e.g., profiles created by "taxonkit profile2cami"
+			v.errorf(lineNo, "TAXPATH has %d items, more than the position (%d) of rank %s in @Ranks", len(taxpath), i+1, rank)
+		}
+	}
+
+	if v.taxondb == nil {
+		return
+	}
+
+	// cross-checking with the taxdump
+	taxid2, status := lookupTaxid(v.taxondb, taxid)
+	switch status {
+	case taxidUnfound:
+		v.warningf(lineNo, "TaxId %d not found in the taxdump", taxid)
+		return
+	case taxidDeleted:
+		v.warningf(lineNo, "TaxId %d was deleted", taxid)
+		return
+	case taxidMerged:
+		v.warningf(lineNo, "TaxId %d was merged into %d", taxid, taxid2)
+	}
+
+	_rank := v.taxondb.Rank(taxid2)
+	if _rank != rank {
+		// aliases in both directions, e.g., "acellular root" of Viruses in the
+		// taxdump is written as "superkingdom" by profile2cami.
+		_, alias := rankAliasMap([]string{rank})[_rank]
+		if !alias {
+			_, alias = rankAliasMap([]string{_rank})[rank]
+		}
+		if !alias {
+			v.warningf(lineNo, "rank of TaxId %d is %s in the taxdump, but %s found", taxid2, _rank, rank)
+		}
+	}
+
+	if name := taxpathsn[len(taxpathsn)-1]; name != v.taxondb.Names[taxid2] {
+		v.warningf(lineNo, "name of TaxId %d is \"%s\" in the taxdump, but \"%s\" found", taxid2, v.taxondb.Names[taxid2], name)
+	}
+
+	lineage := make(map[uint32]interface{}, 32)
+	for _, t := range v.taxondb.LineageTaxIds(taxid2) {
+		lineage[t] = struct{}{}
+	}
+	var t uint32
+	for _, s := range taxpath[:len(taxpath)-1] {
+		if s == "" {
+			continue
+		}
+		if _taxid, err = strconv.Atoi(s); err != nil {
+			v.errorf(lineNo, "invalid TaxId in TAXPATH: %s", s)
+			continue
+		}
+		t, status = lookupTaxid(v.taxondb, uint32(_taxid))
+		if status == taxidUnfound || status == taxidDeleted {
+			continue
+		}
+		if _, ok := lineage[t]; !ok {
+			v.errorf(lineNo, "TaxId %s in TAXPATH is not an ancestor of %d in the taxdump", s, taxid)
+		}
+	}
+}
+
+func init() {
+	RootCmd.AddCommand(camiValidateCmd)
+
+	camiValidateCmd.Flags().StringP("taxid-sep", "", "|", "separator of taxid in TAXPATH and TAXPATHSN")
+	camiValidateCmd.Flags().BoolP("no-taxdump", "n", false, "do not cross-check with the taxdump files")
+	camiValidateCmd.Flags().Float64P("tolerance", "", 0.01, "tolerance for sums of percentages of a rank greater than 100")
+}
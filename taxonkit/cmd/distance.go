@@ -0,0 +1,314 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/shenwei356/breader"
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// distanceCmd represents the distance command
+var distanceCmd = &cobra.Command{
+	Use:   "distance",
+	Short: "Compute taxonomic distance and divergence rank between TaxIds",
+	Long: `Compute taxonomic distance and divergence rank between TaxIds
+
+Input:
+
+  - Pairs of TaxIds in two fields (-i/--taxid-field and -I/--taxid-field2)
+    of tab-delimited file or STDIN.
+  - Or a set of TaxIds, one TaxId per line (-i/--taxid-field), for computing
+    distances of all pairs (-a/--all-pairs).
+
+Output for pairs of TaxIds:
+
+  1. Input line data.
+  2. LCA of the two TaxIds.
+  3. Divergence rank, i.e., the highest canonical rank (-r/--ranks) below
+     the LCA in the two lineages. It's empty if the two TaxIds are identical.
+  4. Path length in edges.
+  5. Path length in canonical-rank steps, i.e., the number of nodes of
+     canonical ranks in the path, the LCA excluded.
+
+  Merged TaxIds are replaced with new ones. For deleted or unfound TaxIds,
+  the LCA is 0 and path lengths are -1.
+
+Output for all pairs (-a/--all-pairs), by -F/--out-format:
+
+  tsv:     a matrix with a header line.
+  phylip:  a square distance matrix in (relaxed) PHYLIP format.
+  pairs:   TaxId pairs and values as the output for pairs of TaxIds.
+
+  Values in matrices are path lengths in edges or in canonical-rank steps,
+  chosen by -m/--metric. Deleted or unfound TaxIds are ignored.
+
+Examples:
+
+    $ echo -e "562\t620" | taxonkit distance
+    562     620     543     genus   3       3
+
+    $ echo -e "562\n620\n9606" | taxonkit distance -a -F phylip -m ranks
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+
+		field := getFlagPositiveInt(cmd, "taxid-field") - 1
+		field2 := getFlagPositiveInt(cmd, "taxid-field2") - 1
+		allPairs := getFlagBool(cmd, "all-pairs")
+
+		outFormat := getFlagString(cmd, "out-format")
+		switch outFormat {
+		case "tsv", "phylip", "pairs":
+		default:
+			checkError(fmt.Errorf("invalid output format: %s, available: tsv, phylip, pairs", outFormat))
+		}
+
+		metric := getFlagString(cmd, "metric")
+		var useRankSteps bool
+		switch metric {
+		case "edges":
+		case "ranks":
+			useRankSteps = true
+		default:
+			checkError(fmt.Errorf("invalid metric: %s, available: edges, ranks", metric))
+		}
+
+		ranks := getFlagStringSlice(cmd, "ranks")
+		if len(ranks) == 0 {
+			checkError(fmt.Errorf("flag -r/--ranks needed"))
+		}
+		rankOrder := make(map[string]int, len(ranks))
+		for i, rank := range ranks {
+			rankOrder[strings.ToLower(rank)] = i
+		}
+		for alias, rank := range rankAliasMap(ranks) {
+			rankOrder[alias] = rankOrder[rank]
+		}
+
+		files := getFileList(args)
+
+		if len(files) == 1 && isStdin(files[0]) && !xopen.IsStdin() {
+			checkError(fmt.Errorf("stdin not detected"))
+		}
+
+		taxondb := loadTaxonomy(&config, true, false)
+
+		outfh, err := xopen.Wopen(config.OutFile)
+		checkError(err)
+		defer outfh.Close()
+
+		// -----------------------------------------------------------------
+		// all pairs
+
+		if allPairs {
+			taxids := make([]uint32, 0, 1024)
+			inputs := make([]string, 0, 1024)
+			visited := make(map[uint32]interface{}, 1024)
+
+			var line string
+			var items []string
+			var _taxid, status int
+			var taxid uint32
+			var ok bool
+			for _, file := range files {
+				fh, err := xopen.Ropen(file)
+				checkError(err)
+
+				scanner := bufio.NewScanner(fh)
+				for scanner.Scan() {
+					line = strings.Trim(scanner.Text(), "\r\n ")
+					if line == "" {
+						continue
+					}
+
+					items = strings.Split(line, "\t")
+					if len(items) <= field {
+						checkError(fmt.Errorf("field index (%d) out of range (%d): %s", field+1, len(items), line))
+					}
+
+					_taxid, err = strconv.Atoi(items[field])
+					if err != nil {
+						continue
+					}
+
+					taxid, status = checkTaxid(taxondb, uint32(_taxid))
+					if status == taxidDeleted || status == taxidUnfound {
+						continue
+					}
+					if _, ok = visited[taxid]; ok {
+						continue
+					}
+					visited[taxid] = struct{}{}
+
+					taxids = append(taxids, taxid)
+					inputs = append(inputs, items[field])
+				}
+				if err := scanner.Err(); err != nil {
+					checkError(err)
+				}
+
+				checkError(fh.Close())
+			}
+
+			if config.Verbose {
+				log.Infof("%d valid TaxIds loaded", len(taxids))
+			}
+
+			n := len(taxids)
+			var d taxonDistance
+			var i, j int
+
+			if outFormat == "pairs" {
+				for i = 0; i < n; i++ {
+					for j = i + 1; j < n; j++ {
+						d = computeTaxonDistance(taxondb, rankOrder, taxids[i], taxids[j])
+						fmt.Fprintf(outfh, "%s\t%s\t%d\t%s\t%d\t%d\n", inputs[i], inputs[j], d.lca, d.divRank, d.edges, d.rankSteps)
+					}
+				}
+				return
+			}
+
+			matrix := make([][]int, n)
+			for i = range matrix {
+				matrix[i] = make([]int, n)
+			}
+			for i = 0; i < n; i++ {
+				for j = i + 1; j < n; j++ {
+					d = computeTaxonDistance(taxondb, rankOrder, taxids[i], taxids[j])
+					if useRankSteps {
+						matrix[i][j] = d.rankSteps
+					} else {
+						matrix[i][j] = d.edges
+					}
+					matrix[j][i] = matrix[i][j]
+				}
+			}
+
+			var sep string
+			if outFormat == "phylip" {
+				sep = " "
+				fmt.Fprintf(outfh, "%d\n", n)
+			} else {
+				sep = "\t"
+				outfh.WriteString("taxid")
+				for _, s := range inputs {
+					outfh.WriteString("\t" + s)
+				}
+				outfh.WriteString("\n")
+			}
+			for i = 0; i < n; i++ {
+				outfh.WriteString(inputs[i])
+				for j = 0; j < n; j++ {
+					outfh.WriteString(sep + strconv.Itoa(matrix[i][j]))
+				}
+				outfh.WriteString("\n")
+			}
+			return
+		}
+
+		// -----------------------------------------------------------------
+		// pairs of TaxIds in each line
+
+		type line2distance struct {
+			line  string
+			valid bool
+			d     taxonDistance
+		}
+
+		maxField := field
+		if field2 > maxField {
+			maxField = field2
+		}
+
+		fn := func(line string) (interface{}, bool, error) {
+			line = strings.Trim(line, "\r\n ")
+			if line == "" {
+				return nil, false, nil
+			}
+
+			data := strings.Split(line, "\t")
+			if len(data) <= maxField {
+				return nil, false, fmt.Errorf("field index (%d) out of range (%d): %s", maxField+1, len(data), line)
+			}
+
+			a, e := strconv.Atoi(data[field])
+			if e != nil {
+				return line2distance{line: line}, true, nil
+			}
+			b, e := strconv.Atoi(data[field2])
+			if e != nil {
+				return line2distance{line: line}, true, nil
+			}
+
+			taxidA, statusA := checkTaxid(taxondb, uint32(a))
+			taxidB, statusB := checkTaxid(taxondb, uint32(b))
+			if statusA == taxidDeleted || statusA == taxidUnfound ||
+				statusB == taxidDeleted || statusB == taxidUnfound {
+				return line2distance{line: line}, true, nil
+			}
+
+			return line2distance{line, true, computeTaxonDistance(taxondb, rankOrder, taxidA, taxidB)}, true, nil
+		}
+
+		for _, file := range files {
+			reader, err := breader.NewBufferedReader(file, config.Threads, 64, fn)
+			checkError(err)
+
+			var l2d line2distance
+			var data interface{}
+			for chunk := range reader.Ch {
+				checkError(chunk.Err)
+
+				for _, data = range chunk.Data {
+					l2d = data.(line2distance)
+
+					if l2d.valid {
+						fmt.Fprintf(outfh, "%s\t%d\t%s\t%d\t%d\n", l2d.line, l2d.d.lca, l2d.d.divRank, l2d.d.edges, l2d.d.rankSteps)
+					} else {
+						outfh.WriteString(l2d.line + "\t0\t\t-1\t-1\n")
+					}
+					if config.LineBuffered {
+						outfh.Flush()
+					}
+				}
+			}
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(distanceCmd)
+
+	distanceCmd.Flags().IntP("taxid-field", "i", 1, "field index of the first TaxId. input data should be tab-separated")
+	distanceCmd.Flags().IntP("taxid-field2", "I", 2, "field index of the second TaxId. input data should be tab-separated")
+	distanceCmd.Flags().StringSliceP("ranks", "r", []string{"superkingdom", "kingdom", "phylum", "class", "order", "family", "genus", "species", "subspecies", "strain"}, "canonical ranks in descending order, for divergence rank and canonical-rank steps")
+
+	distanceCmd.Flags().BoolP("all-pairs", "a", false, "compute distances of all pairs of TaxIds in field -i/--taxid-field")
+	distanceCmd.Flags().StringP("out-format", "F", "tsv", `output format for -a/--all-pairs: "tsv" (matrix), "phylip" (matrix), or "pairs"`)
+	distanceCmd.Flags().StringP("metric", "m", "edges", `values in the matrix: "edges" (path length in edges), or "ranks" (path length in canonical-rank steps)`)
+}
@@ -36,8 +36,8 @@ import (
 // filterCmd represents
 var filterCmd = &cobra.Command{
 	Use:   "filter",
-	Short: "Filter TaxIds by taxonomic rank range",
-	Long: `Filter TaxIds by taxonomic rank range
+	Short: "Filter TaxIds by taxonomic rank range, clades and names",
+	Long: `Filter TaxIds by taxonomic rank range, clades and names
 
 Attentions:
 
@@ -54,6 +54,44 @@ Attentions:
     -n/--save-predictable-norank to save some special ranks without order,
     where rank of the closest higher node is still lower than rank cutoff.
 
+Filtering by clades:
+
+  1. TaxIds belonging to clades (the clade TaxIds and their descendants)
+     given by -I/--include-taxids (--include-taxids-file) are kept, and
+     those of clades given by -X/--exclude-taxids (--exclude-taxids-file)
+     are discarded, e.g., -X 9606,10239 for removing human and viruses.
+  2. When a TaxId belongs to both an included and an excluded clade,
+     the nearest clade decides, e.g., -I 2 -X 1224 keeps bacteria except
+     Proteobacteria.
+  3. Merged TaxIds are replaced with the new ones, deleted or unfound
+     TaxIds in input are discarded.
+  4. It can be used alone or along with filtering by ranks.
+
+Filtering by names:
+
+  1. TaxIds are selected if their scientific names match any of the regular
+     expressions given by --name-regexp, or any names in their lineages
+     (including themselves, excluding the root) match --lineage-regexp.
+  2. -U/--unclassified selects TaxIds of environmental or unclassified
+     entries, i.e., names in their lineages contain "uncultured",
+     "environmental samples", "unclassified" (case ignored), or "sp.".
+  3. Use -v/--invert-match to discard the selected TaxIds instead, e.g.,
+     removing environmental and unclassified entries:
+         taxonkit filter -U -v
+  4. It can be used along with filtering by ranks and clades.
+
+Inferring rank order (--infer-ranks):
+
+  For custom taxonomies with novel ranks (e.g., GTDB, ICTV), a rank file can
+  be created from the taxonomy data, where a partial order of ranks is derived
+  from the ranks of parent-child pairs in nodes.dmp.
+  1. Ranks without order in the rank file (-r/--rank-file or the default one),
+     e.g., "no rank" and "clade", are kept without order ("!" prefixed), and
+     each node is compared with its nearest ancestor of a rank with order.
+  2. Conflicts, e.g., both "A > B" and "B > A" are observed, are resolved
+     by ignoring the relation supported by fewer nodes, and are reported.
+  3. Ranks on the same level are put in one line.
+
 Rank file:
 
   1. Blank lines or lines starting with "#" are ignored.
@@ -71,8 +109,9 @@ Rank file:
 
 		listOrder := getFlagBool(cmd, "list-order")
 		listRanks := getFlagBool(cmd, "list-ranks")
+		inferRanks := getFlagBool(cmd, "infer-ranks")
 
-		if !(listOrder || listRanks) && len(files) == 1 && isStdin(files[0]) && !xopen.IsStdin() {
+		if !(listOrder || listRanks || inferRanks) && len(files) == 1 && isStdin(files[0]) && !xopen.IsStdin() {
 			checkError(fmt.Errorf("stdin not detected"))
 		}
 
@@ -96,6 +135,22 @@ Rank file:
 
 		field := getFlagPositiveInt(cmd, "taxid-field") - 1
 
+		includesStr := getFlagStringSlice(cmd, "include-taxids")
+		includesStr = append(includesStr, readTaxidsFromFiles(config, getFlagStringSlice(cmd, "include-taxids-file"))...)
+		excludesStr := getFlagStringSlice(cmd, "exclude-taxids")
+		excludesStr = append(excludesStr, readTaxidsFromFiles(config, getFlagStringSlice(cmd, "exclude-taxids-file"))...)
+		filterByClades := len(includesStr) > 0 || len(excludesStr) > 0
+
+		nameRegexps := getFlagStringSlice(cmd, "name-regexp")
+		lineageRegexps := getFlagStringSlice(cmd, "lineage-regexp")
+		unclassified := getFlagBool(cmd, "unclassified")
+		ignoreCase := getFlagBool(cmd, "ignore-case")
+		invertMatch := getFlagBool(cmd, "invert-match")
+		filterByNames := len(nameRegexps) > 0 || len(lineageRegexps) > 0 || unclassified
+		if invertMatch && !filterByNames {
+			checkError(fmt.Errorf("flag -v/--invert-match only works along with --name-regexp, --lineage-regexp, or -U/--unclassified"))
+		}
+
 		if higher != "" && lower != "" {
 			checkError(fmt.Errorf("-H/--higher-than and -L/--lower-than can't be simultaneous given"))
 		}
@@ -146,7 +201,38 @@ Rank file:
 			return
 		}
 
-		taxondb := loadTaxonomy(&config, true)
+		taxondb := loadTaxonomy(&config, true, filterByNames)
+
+		if inferRanks {
+			if config.Verbose {
+				log.Infof("inferring rank order from the taxonomy")
+			}
+			levels, unordered, conflicts := inferRankOrder(taxondb, noRanks)
+			for _, c := range conflicts {
+				log.Warningf("rank order conflict: %s", c)
+			}
+
+			outfh, err := xopen.Wopen(config.OutFile)
+			checkError(err)
+			defer outfh.Close()
+
+			outfh.WriteString(fmt.Sprintf("# rank order inferred from: %s\n", config.NodesFile))
+			outfh.WriteString(fmt.Sprintf("# %d conflicts found\n", len(conflicts)))
+			for _, c := range conflicts {
+				outfh.WriteString("#   " + c + "\n")
+			}
+			outfh.WriteString("\n")
+			for _, level := range levels {
+				outfh.WriteString(strings.Join(level, ",") + "\n")
+			}
+			if len(unordered) > 0 {
+				outfh.WriteString("\n")
+				for _, r := range unordered {
+					outfh.WriteString("!" + r + "\n")
+				}
+			}
+			return
+		}
 
 		if config.Verbose {
 			log.Infof("checking defined taxonomic rank order")
@@ -160,7 +246,7 @@ Rank file:
 			}
 		}
 		if len(notDefined) > 0 {
-			checkError(fmt.Errorf("rank order not defined in rank file: %s", strings.Join(notDefined, ", ")))
+			checkError(fmt.Errorf(`rank order not defined in rank file: %s. You may create a rank file with "taxonkit filter --infer-ranks -o ranks.txt" and use it via -r/--rank-file`, strings.Join(notDefined, ", ")))
 		}
 		if config.Verbose {
 			log.Infof("checking defined taxonomic rank order passed")
@@ -209,6 +295,24 @@ Rank file:
 		filter, err := newRankFilter(taxondb, rankOrder, noRanks, lower, higher, equals, blackListRanks, discardNoRank, saveNorank)
 		checkError(err)
 
+		var cfilter *cladeFilter
+		if filterByClades {
+			includes, err := parseClades(taxondb, includesStr)
+			checkError(err)
+			excludes, err := parseClades(taxondb, excludesStr)
+			checkError(err)
+			if config.Verbose {
+				log.Infof("%d clades to include, %d clades to exclude", len(includes), len(excludes))
+			}
+			cfilter = newCladeFilter(taxondb, includes, excludes)
+		}
+
+		var nfilter *nameFilter
+		if filterByNames {
+			nfilter, err = newNameFilter(taxondb, nameRegexps, lineageRegexps, unclassified, ignoreCase, invertMatch)
+			checkError(err)
+		}
+
 		outfh, err := xopen.Wopen(config.OutFile)
 		checkError(err)
 		defer outfh.Close()
@@ -261,6 +365,14 @@ Rank file:
 					continue
 				}
 
+				if filterByClades && !cfilter.isPassed(taxid) {
+					continue
+				}
+
+				if filterByNames && !nfilter.isPassed(taxid) {
+					continue
+				}
+
 				outfh.WriteString(line + "\n")
 			}
 			if err := scanner.Err(); err != nil {
@@ -279,6 +391,7 @@ func init() {
 	filterCmd.Flags().StringP("rank-file", "r", "", `user-defined ordered taxonomic ranks, type "taxonkit filter --help" for details`)
 	filterCmd.Flags().BoolP("list-order", "", false, `list user defined ranks in order, from "$HOME/.taxonkit/ranks.txt"`)
 	filterCmd.Flags().BoolP("list-ranks", "", false, `list ordered ranks in taxonomy database, sorted in user defined order`)
+	filterCmd.Flags().BoolP("infer-ranks", "", false, `infer rank order from parent-child pairs in nodes.dmp, and output a rank file, type "taxonkit filter --help" for details`)
 
 	filterCmd.Flags().BoolP("discard-noranks", "N", false, `discard all ranks without order, type "taxonkit filter --help" for details`)
 	filterCmd.Flags().BoolP("save-predictable-norank", "n", false, `do not discard some special ranks without order when using -L, where rank of the closest higher node is still lower than rank cutoff`)
@@ -291,5 +404,16 @@ func init() {
 	filterCmd.Flags().StringP("higher-than", "H", "", "output TaxIds with rank higher than a rank, exclusive with --lower-than")
 	filterCmd.Flags().StringSliceP("equal-to", "E", []string{}, `output TaxIds with rank equal to some ranks, multiple values can be separated with comma "," (e.g., -E "genus,species"), or give multiple times (e.g., -E genus -E species)`)
 
+	filterCmd.Flags().StringSliceP("include-taxids", "I", []string{}, `only output TaxIds belonging to these clades, i.e., the TaxIds and their descendants, multiple values can be separated with comma ","`)
+	filterCmd.Flags().StringSliceP("include-taxids-file", "", []string{}, `file(s) of TaxIds of clades to include, one TaxId per line`)
+	filterCmd.Flags().StringSliceP("exclude-taxids", "X", []string{}, `discard TaxIds belonging to these clades, i.e., the TaxIds and their descendants, multiple values can be separated with comma ","`)
+	filterCmd.Flags().StringSliceP("exclude-taxids-file", "", []string{}, `file(s) of TaxIds of clades to exclude, one TaxId per line`)
+
+	filterCmd.Flags().StringSliceP("name-regexp", "", []string{}, `select TaxIds whose scientific names match the regular expression, can be given multiple times`)
+	filterCmd.Flags().StringSliceP("lineage-regexp", "", []string{}, `select TaxIds with any names in their lineages matching the regular expression, can be given multiple times`)
+	filterCmd.Flags().BoolP("unclassified", "U", false, `select TaxIds of environmental or unclassified entries, type "taxonkit filter --help" for details`)
+	filterCmd.Flags().BoolP("ignore-case", "", false, `ignore case of --name-regexp and --lineage-regexp`)
+	filterCmd.Flags().BoolP("invert-match", "v", false, `discard TaxIds selected by names, instead of keeping them`)
+
 	filterCmd.Flags().IntP("taxid-field", "i", 1, "field index of taxid. input data should be tab-separated")
 }
@@ -22,12 +22,14 @@ package cmd
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
 
 	"github.com/shenwei356/util/bytesize"
+	"github.com/shenwei356/util/stringutil"
 	"github.com/shenwei356/xopen"
 	"github.com/spf13/cobra"
 )
@@ -46,6 +48,51 @@ Attention:
      single charactor separator is prefered.
   3. Empty lines or lines without valid TaxIds in the field are omitted.
   4. If some TaxIds are not found in database, it returns 0.
+
+Output:
+
+  1. Input line data, or the group key for -g/--group-field.
+  2. LCA TaxId.
+  3. (Optional) Support of the LCA, for -m/--majority or -t/--threshold.
+  4. (Optional) Name (-n/--show-name).
+  5. (Optional) Rank (-r/--show-rank).
+  6. (Optional) Lineage (-l/--show-lineage), delimiter can be changed with
+     flag -d/--delimiter, or reformatted with -f/--lineage-format.
+  7. (Optional) Numbers of merged, deleted and unfound TaxIds (--show-count).
+
+Consensus LCA:
+
+  A single spurious TaxId (e.g., a wrong BLAST hit) could collapse the
+  strict LCA to a high rank. Instead, you can compute the deepest node
+  supported by a fraction of TaxIds:
+
+    -m/--majority     the deepest node supported by more than 50% of TaxIds.
+    -t/--threshold    the deepest node supported by at least X% of TaxIds.
+
+  The support (fraction of TaxIds under the node) is appended after the LCA.
+  When the threshold is <= 50, several deepest nodes might qualify,
+  the one with the highest support is returned.
+
+  TaxIds can be weighted with values (e.g., bitscores) from another field
+  (-w/--weight-field), which should have the same separator and the same
+  number of values as TaxIds. -P/--top-percent only keeps TaxIds with weights
+  within the given percentage of the best one, like MEGAN does, and it works
+  for both strict and consensus LCA.
+
+Grouped LCA:
+
+  For BLAST/DIAMOND tabular output with one hit per line, -g/--group-field
+  specifies the field of group key (query ID), and TaxIds (-i/--taxids-field)
+  of all lines with the same key are used to compute the LCA. One line of
+  "key, LCA" is output for each group.
+
+  1. TaxIds in a line can be separated with ";" (default in this mode),
+     e.g., the "staxids" column.
+  2. Lines of a group should be consecutive, or use -u/--unsorted.
+  3. Weights (-w/--weight-field) are values of a single field in each line.
+  4. Lines can be filtered by scores (--score-field, --min-score) and
+     identities (--identity-field, --min-identity).
+  5. Lines starting with "#" are ignored.
   
 Examples:
 
@@ -55,6 +102,20 @@ Examples:
     $ time echo 239934  239935  349741 9606  | taxonkit lca
     239934 239935 349741 9606       131567
 
+    $ echo 239934  239935  349741 9606  | taxonkit lca -m
+    239934 239935 349741 9606       239934  0.7500
+
+    $ echo -e "239934 239935 349741 9606\t300 290 280 100" \
+        | taxonkit lca -w 2 -P 10
+    239934 239935 349741 9606       300 290 280 100 239934
+
+    $ echo 562 620 | taxonkit lca -n -r
+    562 620 543     Enterobacteriaceae      family
+
+    # qseqid, sseqid, pident, ..., bitscore, staxids
+    $ taxonkit lca -g 1 -i 13 -w 12 -P 10 --identity-field 3 --min-identity 90 \
+        blast.tsv
+
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		config := getConfigs(cmd)
@@ -82,6 +143,62 @@ Examples:
 		skipDeleted := getFlagBool(cmd, "skip-deleted")
 		skipUnfound := getFlagBool(cmd, "skip-unfound")
 
+		majority := getFlagBool(cmd, "majority")
+		threshold := getFlagPositiveFloat64(cmd, "threshold")
+		if threshold > 100 {
+			checkError(fmt.Errorf("value of flag -t/--threshold should be in range of (0, 100]"))
+		}
+		if majority && cmd.Flags().Lookup("threshold").Changed {
+			checkError(fmt.Errorf("flag -m/--majority and -t/--threshold are exclusive"))
+		}
+		weightField := getFlagNonNegativeInt(cmd, "weight-field") - 1
+		useWeight := weightField >= 0
+		topPercent := getFlagNonNegativeFloat64(cmd, "top-percent")
+		if topPercent >= 100 {
+			checkError(fmt.Errorf("value of flag -P/--top-percent should be in range of [0, 100)"))
+		}
+		if topPercent > 0 && !useWeight {
+			checkError(fmt.Errorf("flag -P/--top-percent needs weights given by -w/--weight-field"))
+		}
+
+		groupField := getFlagNonNegativeInt(cmd, "group-field") - 1
+		grouping := groupField >= 0
+		unsorted := getFlagBool(cmd, "unsorted")
+		scoreField := getFlagNonNegativeInt(cmd, "score-field") - 1
+		minScore := getFlagFloat64(cmd, "min-score")
+		identityField := getFlagNonNegativeInt(cmd, "identity-field") - 1
+		minIdentity := getFlagFloat64(cmd, "min-identity")
+
+		if grouping {
+			if !cmd.Flags().Lookup("separator").Changed && !cmd.Flags().Lookup("separater").Changed {
+				separator = ";"
+			}
+		} else {
+			if unsorted {
+				checkError(fmt.Errorf("flag -u/--unsorted only works along with -g/--group-field"))
+			}
+			if scoreField >= 0 || identityField >= 0 {
+				checkError(fmt.Errorf("flag --score-field and --identity-field only work along with -g/--group-field"))
+			}
+		}
+
+		printName := getFlagBool(cmd, "show-name")
+		printRank := getFlagBool(cmd, "show-rank")
+		printLineage := getFlagBool(cmd, "show-lineage")
+		delimiter := getFlagString(cmd, "delimiter")
+		lineageFormat := getFlagString(cmd, "lineage-format")
+		showCount := getFlagBool(cmd, "show-count")
+		if lineageFormat != "" {
+			if !printLineage {
+				checkError(fmt.Errorf("flag -f/--lineage-format only works along with -l/--show-lineage"))
+			}
+			_, err := newRankPlaceHolders().parseFormat(lineageFormat)
+			checkError(err)
+		}
+		unescape := stringutil.UnEscaper()
+
+		maxField := MaxInts(field, groupField, weightField, scoreField, identityField)
+
 		bufferSizeS := getFlagString(cmd, "buffer-size")
 		if bufferSizeS == "" {
 			checkError(fmt.Errorf("value of buffer size. supported unit: K, M, G"))
@@ -91,10 +208,10 @@ Examples:
 			checkError(fmt.Errorf("invalid value of buffer size. supported unit: K, M, G"))
 		}
 
-		taxondb := loadTaxonomy(&config, false)
-		nodes := taxondb.Nodes
-		merged := taxondb.MergeNodes
-		delnodes := taxondb.DelNodes
+		taxondb := loadTaxonomy(&config, printRank || lineageFormat != "", printName || printLineage)
+
+		computer := newLCAComputer(taxondb, majority, threshold, topPercent)
+		showSupport := computer.consensus
 
 		outfh, err := xopen.Wopen(config.OutFile)
 		checkError(err)
@@ -102,7 +219,117 @@ Examples:
 
 		buf := make([]byte, bufferSize)
 
-		taxids := make([]uint32, 0, 128)
+		// add a TaxId (in string) to a record
+		addTaxid := func(r *lcaRecord, item string, weight float64) {
+			item = reNonTaxid.ReplaceAllString(item, "")
+			if item == "" {
+				return
+			}
+
+			_taxid, _ := strconv.Atoi(item)
+			taxid, status := checkTaxid(taxondb, uint32(_taxid))
+			switch status {
+			case taxidMerged:
+				r.nMerged++
+			case taxidDeleted:
+				r.nDeleted++
+				if !skipDeleted {
+					r.failed = true
+				}
+				return
+			case taxidUnfound:
+				r.nUnfound++
+				if !skipUnfound {
+					r.failed = true
+				}
+				return
+			}
+
+			r.taxids = append(r.taxids, taxid)
+			if useWeight {
+				r.weights = append(r.weights, weight)
+			}
+		}
+
+		// compute and output LCA of a record
+		var obuf bytes.Buffer
+		output := func(r *lcaRecord) {
+			var lca uint32
+			var support float64
+			if !r.failed {
+				if len(r.taxids) == 0 {
+					return
+				}
+				lca, support = computer.compute(r.taxids, r.weights)
+			}
+
+			obuf.Reset()
+			obuf.WriteString(r.key)
+			obuf.WriteString("\t" + strconv.Itoa(int(lca)))
+
+			if showSupport {
+				if r.failed {
+					obuf.WriteString(fmt.Sprintf("\t%.4f", 0.0))
+				} else {
+					obuf.WriteString(fmt.Sprintf("\t%.4f", support))
+				}
+			}
+
+			if printName {
+				obuf.WriteString("\t" + taxondb.Names[lca])
+			}
+			if printRank {
+				obuf.WriteString("\t" + taxondb.Rank(lca))
+			}
+			if printLineage {
+				obuf.WriteByte('\t')
+				if lca > 0 {
+					lineageTaxids := taxondb.LineageTaxIds(lca)
+					names := make([]string, len(lineageTaxids))
+					for i, taxid := range lineageTaxids {
+						names[i] = taxondb.Names[taxid]
+					}
+					if lineageFormat == "" {
+						obuf.WriteString(strings.Join(names, delimiter))
+					} else {
+						ranks := make([]string, len(lineageTaxids))
+						for i, taxid := range lineageTaxids {
+							ranks[i] = taxondb.Rank(taxid)
+						}
+						obuf.WriteString(unescape(fillRankPlaceHolders(lineageFormat, names, ranks)))
+					}
+				}
+			}
+
+			if showCount {
+				obuf.WriteString(fmt.Sprintf("\t%d\t%d\t%d", r.nMerged, r.nDeleted, r.nUnfound))
+			}
+
+			obuf.WriteByte('\n')
+			outfh.Write(obuf.Bytes())
+			if config.LineBuffered {
+				outfh.Flush()
+			}
+		}
+
+		newRecord := func(key string) *lcaRecord {
+			r := &lcaRecord{key: key, taxids: make([]uint32, 0, 128)}
+			if useWeight {
+				r.weights = make([]float64, 0, 128)
+			}
+			return r
+		}
+
+		record := newRecord("")
+
+		// for unsorted groups
+		var key2record map[string]*lcaRecord
+		var keys []string
+		if unsorted {
+			key2record = make(map[string]*lcaRecord, 1024)
+			keys = make([]string, 0, 1024)
+		}
+
 		for _, file := range files {
 			fh, err := xopen.Ropen(file)
 			checkError(err)
@@ -110,86 +337,129 @@ Examples:
 			scanner := bufio.NewScanner(fh)
 			scanner.Buffer(buf, int(bufferSize))
 
-			var _taxid int
-			var line, item string
-			var items []string
-			var lca, taxid, taxid2 uint32
-			var ok, flag bool
+			var line, item, key string
+			var items, witems []string
+			var weight, value float64
+			var i int
+			var ok bool
+			var r *lcaRecord
 			for scanner.Scan() {
 				line = strings.Trim(scanner.Text(), "\r\n ")
 				if line == "" {
 					continue
 				}
 
-				lca = 0
+				// -------------------------------------------------------------
+				// multiple lines per group, e.g., BLAST/DIAMOND tabular output
 
-				items = strings.Split(line, "\t")
-				if len(items) <= field {
-					field = len(items) - 1
-				}
+				if grouping {
+					if line[0] == '#' {
+						continue
+					}
 
-				if items[field] == "" {
-					continue
-				}
+					items = strings.Split(line, "\t")
+					if len(items) <= maxField {
+						checkError(fmt.Errorf("field index (%d) out of range (%d): %s", maxField+1, len(items), line))
+					}
 
-				items = strings.Split(items[field], separator)
+					key = items[groupField]
 
-				taxids = taxids[:0]
+					if unsorted {
+						if r, ok = key2record[key]; !ok {
+							r = newRecord(CopyString(key))
+							key2record[r.key] = r
+							keys = append(keys, r.key)
+						}
+					} else {
+						if key != record.key {
+							output(record)
+							record.reset(key)
+						}
+						r = record
+					}
 
-				flag = false
-				for _, item = range items {
-					item = reNonTaxid.ReplaceAllString(item, "")
-					if item == "" {
+					if r.failed && !showCount {
 						continue
 					}
 
-					_taxid, _ = strconv.Atoi(item)
-					taxid = uint32(_taxid)
-
-					_, ok = nodes[taxid]
-					if ok {
-						taxids = append(taxids, taxid)
-						continue
+					if scoreField >= 0 {
+						value, err = strconv.ParseFloat(items[scoreField], 64)
+						if err != nil {
+							checkError(fmt.Errorf("invalid score: %s. line: %s", items[scoreField], line))
+						}
+						if value < minScore {
+							continue
+						}
+					}
+					if identityField >= 0 {
+						value, err = strconv.ParseFloat(items[identityField], 64)
+						if err != nil {
+							checkError(fmt.Errorf("invalid identity: %s. line: %s", items[identityField], line))
+						}
+						if value < minIdentity {
+							continue
+						}
 					}
 
-					if _, ok = delnodes[taxid]; ok {
-						log.Warningf("taxid %d was deleted", taxid)
-						if !skipDeleted {
-							flag = true
-							break
+					if useWeight {
+						weight, err = strconv.ParseFloat(items[weightField], 64)
+						if err != nil || weight < 0 {
+							checkError(fmt.Errorf("invalid weight: %s. line: %s", items[weightField], line))
 						}
-						continue
 					}
-					if taxid2, ok = merged[taxid]; ok {
-						log.Warningf("taxid %d was merged into %d", taxid, taxid2)
-						taxid = taxid2
-						taxids = append(taxids, taxid)
-					} else {
-						log.Warningf("taxid %d not found", taxid)
-						if !skipUnfound {
-							flag = true
+
+					for _, item = range strings.Split(items[field], separator) {
+						addTaxid(r, item, weight)
+						if r.failed && !showCount {
 							break
 						}
 					}
-				}
-				if flag {
-					outfh.WriteString(fmt.Sprintf("%s\t%d\n", line, 0))
+
 					continue
 				}
 
-				switch len(taxids) {
-				case 0:
+				// -------------------------------------------------------------
+				// one line per record
+
+				items = strings.Split(line, "\t")
+				if len(items) <= field {
+					field = len(items) - 1
+				}
+
+				if items[field] == "" {
 					continue
-				case 1:
-					lca = taxids[0]
-				default:
-					lca = taxids[0]
-					for _, taxid = range taxids {
-						lca = taxondb.LCA(lca, taxid)
+				}
+
+				if useWeight {
+					if len(items) <= weightField {
+						checkError(fmt.Errorf("weight field (%d) out of range (%d): %s", weightField+1, len(items), line))
 					}
+					witems = strings.Split(items[weightField], separator)
 				}
 
-				outfh.WriteString(fmt.Sprintf("%s\t%d\n", line, lca))
+				items = strings.Split(items[field], separator)
+
+				if useWeight && len(witems) != len(items) {
+					checkError(fmt.Errorf("numbers of TaxIds (%d) and weights (%d) do not match: %s", len(items), len(witems), line))
+				}
+
+				record.reset(line)
+
+				for i, item = range items {
+					if useWeight {
+						weight, err = strconv.ParseFloat(strings.TrimSpace(witems[i]), 64)
+						if err != nil || weight < 0 {
+							checkError(fmt.Errorf("invalid weight: %s. line: %s", witems[i], line))
+						}
+					}
+
+					addTaxid(record, item, weight)
+					if record.failed && !showCount {
+						break
+					}
+				}
+
+				output(record)
 			}
 			if err := scanner.Err(); err != nil {
 				checkError(err)
@@ -198,6 +468,15 @@ Examples:
 			checkError(fh.Close())
 		}
 
+		if grouping {
+			if unsorted {
+				for _, key := range keys {
+					output(key2record[key])
+				}
+			} else if record.key != "" {
+				output(record)
+			}
+		}
 	},
 }
 
@@ -210,6 +489,25 @@ func init() {
 	lcaCmd.Flags().StringP("separator", "s", " ", "separator for TaxIds")
 	lcaCmd.Flags().BoolP("skip-deleted", "D", false, "skip deleted TaxIds and compute with left ones")
 	lcaCmd.Flags().BoolP("skip-unfound", "U", false, "skip unfound TaxIds and compute with left ones")
+	lcaCmd.Flags().BoolP("majority", "m", false, "majority-vote mode: return the deepest node supported by more than 50% of TaxIds")
+	lcaCmd.Flags().Float64P("threshold", "t", 100, "consensus mode: return the deepest node supported by at least X% of TaxIds, 100 for strict LCA")
+	lcaCmd.Flags().IntP("weight-field", "w", 0, "field index of weights (e.g., bitscores) of TaxIds, with the same separator as TaxIds. 0 for equal weights")
+	lcaCmd.Flags().Float64P("top-percent", "P", 0, "only keep TaxIds with weights within this percentage of the best one (MEGAN-style), 0 for no filter")
+
+	lcaCmd.Flags().BoolP("show-name", "n", false, `appending scientific name of the LCA`)
+	lcaCmd.Flags().BoolP("show-rank", "r", false, `appending rank of the LCA`)
+	lcaCmd.Flags().BoolP("show-lineage", "l", false, `appending lineage of the LCA`)
+	lcaCmd.Flags().StringP("delimiter", "d", ";", "field delimiter in lineage")
+	lcaCmd.Flags().StringP("lineage-format", "f", "", `reformat the lineage with placeholders of ranks like "taxonkit reformat -f", e.g., "{k};{p};{c};{o};{f};{g};{s}", or "{rank:subfamily}" for other ranks. Missing ranks are left empty`)
+	lcaCmd.Flags().BoolP("show-count", "", false, `appending numbers of merged, deleted and unfound TaxIds`)
+
+	lcaCmd.Flags().IntP("group-field", "g", 0, "field index of group key (e.g., query IDs in BLAST/DIAMOND tabular output), one LCA is computed for each group of lines. 0 for no grouping")
+	lcaCmd.Flags().BoolP("unsorted", "u", false, "lines of a group are not consecutive, all groups are kept in memory and output in order of first appearance")
+	lcaCmd.Flags().IntP("score-field", "", 0, "field index of score (e.g., bitscore) for filtering lines with --min-score, only for -g/--group-field")
+	lcaCmd.Flags().Float64P("min-score", "", 0, "minimum score of lines, used along with --score-field")
+	lcaCmd.Flags().IntP("identity-field", "", 0, "field index of identity (e.g., pident) for filtering lines with --min-identity, only for -g/--group-field")
+	lcaCmd.Flags().Float64P("min-identity", "", 0, "minimum identity of lines, used along with --identity-field")
+
 	lcaCmd.Flags().StringP("buffer-size", "b", "1M", `size of line buffer, supported unit: K, M, G. You need to increase the value when "bufio.Scanner: token too long" error occured`)
 
 }
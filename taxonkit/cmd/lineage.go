@@ -22,11 +22,13 @@ package cmd
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"strings"
 	"sync"
 
+	"github.com/pkg/errors"
 	"github.com/shenwei356/breader"
 	"github.com/shenwei356/util/stringutil"
 	"github.com/shenwei356/xopen"
@@ -59,6 +61,29 @@ Output:
   5. (Optional) Name (-n/--show-name)
   6. (Optional) Rank (-r/--show-rank)
 
+Structured output (--format json/jsonl):
+
+  One JSON object for each input record, with these keys:
+    - "fields":  fields of the input line.
+    - "query":   the query TaxId.
+    - "status":  "found", "merged", "deleted", or "unfound".
+    - "taxid":   the TaxId, or the new one for merged TaxIds. 0 for others.
+    - "name":    the scientific name.
+    - "rank":    the rank.
+    - "lineage": an array of lineage nodes: {"taxid", "name", "rank"}.
+                 It's omitted with -L/--no-lineage.
+  "json" outputs an array of all objects, "jsonl" outputs one object per line.
+  Flags -c/-t/-n/-r/-R are ignored in these formats.
+
+Restricting lineage to selected ranks:
+
+  --ranks keeps only nodes at the given ranks in the lineage,
+  e.g., --ranks superkingdom,phylum,class,order,family,genus,species.
+  Or use --ordered-ranks to keep nodes with ranks ordered in the rank file
+  (~/.taxonkit/ranks.txt or --rank-file, see "taxonkit filter --help"),
+  i.e., ranks without order like "no rank" and "clade" are removed.
+  Lineages of taxids (-t) and ranks (-R) are filtered consistently.
+
 Filter out invalid and deleted taxids, and replace merged 
 taxids with new ones:
     
@@ -87,13 +112,51 @@ taxids with new ones:
 		showCode := getFlagBool(cmd, "show-status-code")
 		noLineage := getFlagBool(cmd, "no-lineage")
 
+		outFormat := getFlagString(cmd, "format")
+		var jsonFormat, jsonlFormat bool
+		switch outFormat {
+		case "tsv":
+		case "json":
+			jsonFormat = true
+		case "jsonl":
+			jsonlFormat = true
+		default:
+			checkError(fmt.Errorf("invalid output format: %s, available: tsv, json, jsonl", outFormat))
+		}
+		structured := jsonFormat || jsonlFormat
+
+		ranksS := getFlagStringSlice(cmd, "ranks")
+		orderedRanks := getFlagBool(cmd, "ordered-ranks")
+		rankFile := getFlagString(cmd, "rank-file")
+		if len(ranksS) > 0 && orderedRanks {
+			checkError(fmt.Errorf("flag --ranks and --ordered-ranks are exclusive"))
+		}
+		var keepRanks map[string]interface{}
+		if len(ranksS) > 0 {
+			keepRanks = make(map[string]interface{}, len(ranksS))
+			for _, rank := range ranksS {
+				keepRanks[strings.ToLower(rank)] = struct{}{}
+			}
+			for alias := range rankAliasMap(ranksS) {
+				keepRanks[alias] = struct{}{}
+			}
+		} else if orderedRanks {
+			rankOrder, _, err := readRankOrder(config, rankFile)
+			checkError(errors.Wrap(err, rankFile))
+			keepRanks = make(map[string]interface{}, len(rankOrder))
+			for rank := range rankOrder {
+				keepRanks[rank] = struct{}{}
+			}
+		}
+		filterRanks := keepRanks != nil
+
 		files := getFileList(args)
 
 		if len(files) == 1 && isStdin(files[0]) && !xopen.IsStdin() {
 			checkError(fmt.Errorf("stdin not detected"))
 		}
 
-		if noLineage && !printRank && !printName {
+		if noLineage && !printRank && !printName && !structured {
 			checkError(fmt.Errorf("when given -L/--no-lineage, -n/--show-name or/and -r/--show-rank needed"))
 		}
 
@@ -104,7 +167,7 @@ taxids with new ones:
 		var names map[uint32]string
 		var delnodes map[uint32]struct{}
 		var merged map[uint32]uint32
-		tree, ranks, names, delnodes, merged = loadData(config, true, printRank || printLineageInRank)
+		tree, ranks, names, delnodes, merged = loadData(config, true, printRank || printLineageInRank || structured || filterRanks)
 
 		// -------------------- load data ----------------------
 
@@ -119,6 +182,9 @@ taxids with new ones:
 			lineageInTaxid string
 			lineageInRank  string
 			notFound       bool
+
+			// for structured output
+			record *lineageRecord
 		}
 
 		var poolStrings = &sync.Pool{New: func() interface{} {
@@ -136,12 +202,21 @@ taxids with new ones:
 				field = len(data) - 1
 			}
 
+			var record *lineageRecord
+			if structured {
+				record = &lineageRecord{Fields: data, Query: data[field], Status: "unfound"}
+			}
+
 			if data[field] == "" {
-				return taxid2lineage{line, 0, "", "", "", false}, true, nil
+				return taxid2lineage{line, 0, "", "", "", false, record}, true, nil
 			}
 			id, e := strconv.Atoi(data[field])
 			if e != nil {
-				return taxid2lineage{line, 0, "", "", "", false}, true, nil
+				return taxid2lineage{line, 0, "", "", "", false, record}, true, nil
+			}
+
+			if structured {
+				return taxid2lineage{line: line, record: queryLineageRecord(record, tree, ranks, names, delnodes, merged, uint32(id), !noLineage, keepRanks)}, true, nil
 			}
 
 			// lineage := make([]string, 0, 16)
@@ -184,11 +259,22 @@ taxids with new ones:
 					}
 				}
 
-				lineage = append(lineage, names[child])
 				if noLineage {
 					break
 				}
 
+				if filterRanks {
+					if _, ok = keepRanks[ranks[child]]; !ok {
+						if parent == 1 {
+							break
+						}
+						child = parent
+						continue
+					}
+				}
+
+				lineage = append(lineage, names[child])
+
 				if printLineageInTaxid {
 					lineageInTaxid = append(lineageInTaxid, strconv.Itoa(int(child)))
 				}
@@ -228,10 +314,15 @@ taxids with new ones:
 				lineageInTaxidS,
 				lineageInRankS,
 				notFound,
+				nil,
 			}, true, nil
 		}
 
 		var buf bytes.Buffer
+		var nRecords int
+		if jsonFormat {
+			outfh.WriteString("[\n")
+		}
 		for _, file := range files {
 			reader, err := breader.NewBufferedReader(file, config.Threads, 10, fn)
 			checkError(err)
@@ -243,6 +334,23 @@ taxids with new ones:
 				for _, data := range chunk.Data {
 					t2l = data.(taxid2lineage)
 
+					if structured {
+						b, err := json.Marshal(t2l.record)
+						checkError(err)
+						if jsonFormat && nRecords > 0 {
+							outfh.WriteString(",\n")
+						}
+						outfh.Write(b)
+						if jsonlFormat {
+							outfh.WriteString("\n")
+						}
+						nRecords++
+						if config.LineBuffered {
+							outfh.Flush()
+						}
+						continue
+					}
+
 					buf.Reset()
 					buf.WriteString(t2l.line)
 
@@ -281,10 +389,96 @@ taxids with new ones:
 				}
 			}
 		}
+		if jsonFormat {
+			if nRecords > 0 {
+				outfh.WriteString("\n")
+			}
+			outfh.WriteString("]\n")
+		}
 
 	},
 }
 
+// lineageNode is a node in a lineage, for structured output.
+type lineageNode struct {
+	Taxid uint32 `json:"taxid"`
+	Name  string `json:"name"`
+	Rank  string `json:"rank"`
+}
+
+// lineageRecord is the lineage information of a query, for structured output.
+type lineageRecord struct {
+	Fields  []string      `json:"fields"`
+	Query   string        `json:"query"`
+	Status  string        `json:"status"`
+	Taxid   uint32        `json:"taxid"`
+	Name    string        `json:"name"`
+	Rank    string        `json:"rank"`
+	Lineage []lineageNode `json:"lineage,omitempty"`
+}
+
+// queryLineageRecord fills the status, name, rank and lineage of a TaxId.
+func queryLineageRecord(
+	record *lineageRecord,
+	tree map[uint32]uint32,
+	ranks map[uint32]string,
+	names map[uint32]string,
+	delnodes map[uint32]struct{},
+	merged map[uint32]uint32,
+	taxid uint32,
+	withLineage bool,
+	keepRanks map[string]interface{},
+) *lineageRecord {
+	var ok bool
+	var newtaxid uint32
+	if _, ok = tree[taxid]; ok {
+		record.Status = "found"
+	} else if _, ok = delnodes[taxid]; ok {
+		log.Warningf("taxid %d was deleted", taxid)
+		record.Status = "deleted"
+		return record
+	} else if newtaxid, ok = merged[taxid]; ok {
+		log.Warningf("taxid %d was merged into %d", taxid, newtaxid)
+		record.Status = "merged"
+		taxid = newtaxid
+	} else {
+		log.Warningf("taxid %d not found", taxid)
+		record.Status = "unfound"
+		return record
+	}
+
+	record.Taxid = taxid
+	record.Name = names[taxid]
+	record.Rank = ranks[taxid]
+
+	if !withLineage {
+		return record
+	}
+
+	nodes := make([]lineageNode, 0, 16)
+	child := taxid
+	var parent uint32
+	for {
+		if keepRanks == nil {
+			nodes = append(nodes, lineageNode{Taxid: child, Name: names[child], Rank: ranks[child]})
+		} else if _, ok = keepRanks[ranks[child]]; ok {
+			nodes = append(nodes, lineageNode{Taxid: child, Name: names[child], Rank: ranks[child]})
+		}
+
+		parent = tree[child]
+		if parent == 1 || parent == child || parent == 0 {
+			break
+		}
+		child = parent
+	}
+	for i, j := 0, len(nodes)-1; i < j; i, j = i+1, j-1 {
+		nodes[i], nodes[j] = nodes[j], nodes[i]
+	}
+	record.Lineage = nodes
+
+	return record
+}
+
 func init() {
 	RootCmd.AddCommand(lineageCmd)
 	lineageCmd.Flags().BoolP("show-status-code", "c", false, "show status code before lineage")
@@ -294,5 +488,9 @@ func init() {
 	lineageCmd.Flags().BoolP("show-name", "n", false, `appending scientific name`)
 	lineageCmd.Flags().IntP("taxid-field", "i", 1, "field index of taxid. input data should be tab-separated")
 	lineageCmd.Flags().StringP("delimiter", "d", ";", "field delimiter in lineage")
+	lineageCmd.Flags().StringSliceP("ranks", "", []string{}, `only keep nodes at these ranks in the lineage, multiple values can be separated with comma`)
+	lineageCmd.Flags().BoolP("ordered-ranks", "", false, `only keep nodes with ranks ordered in the rank file, i.e., removing "no rank", "clade", etc.`)
+	lineageCmd.Flags().StringP("rank-file", "", "", `user-defined ordered taxonomic ranks for --ordered-ranks, type "taxonkit filter --help" for details`)
+	lineageCmd.Flags().StringP("format", "", "tsv", `output format: "tsv", "json" (an array of objects), or "jsonl" (one object per line)`)
 	lineageCmd.Flags().BoolP("no-lineage", "L", false, "do not show lineage, when user just want names or/and ranks")
 }
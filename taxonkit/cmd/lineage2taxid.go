@@ -0,0 +1,318 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shenwei356/breader"
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// lineage2taxidCmd represents the lineage2taxid command
+var lineage2taxidCmd = &cobra.Command{
+	Use:   "lineage2taxid",
+	Short: "Convert lineages to TaxIds of the deepest resolvable taxa",
+	Long: `Convert lineages to TaxIds of the deepest resolvable taxa
+
+Input:
+
+  - Lineages, e.g.,
+        Bacteria;Bacillota;Bacilli;Bacillales;Bacillaceae;Bacillus
+        d__Bacteria;p__Firmicutes;c__Bacilli;o__Bacillales;f__Bacillaceae;g__Bacillus
+        k__Bacteria|p__Firmicutes|c__Bacilli|o__Bacillales|s__Bacillus_subtilis
+  - Or tab-delimited format, the lineage field is specified by -i/--lineage-field.
+  - Supporting (gzipped) file or STDIN.
+
+Method:
+
+  1. Prefixes of ranks (e.g., "d__", "p__", "D_0__", "d:") are removed with
+     the regular expression of -p/--prefix-regexp, and empty levels are skipped.
+  2. Levels are resolved from top to bottom. For each level, the TaxId is
+     queried via the link of child name -> parent name -> TaxId,
+     or the taxa of the name which are descendants of the last resolved one,
+     since lineages might not contain all intermediate nodes.
+     Underscores are treated as spaces if the name is not found.
+  3. The resolution stops at the first level which is not found or ambiguous.
+     For ambiguous levels, you can use -a/--output-ambiguous-result to
+     return the one with the smallest TaxId and continue.
+
+Output:
+
+  1. Input line data.
+  2. TaxId of the deepest resolved taxon, empty for none.
+  3. (Optional) Name (-n/--show-name).
+  4. (Optional) Rank (-r/--show-rank).
+  5. Status:
+       full       all levels are resolved.
+       partial    some levels are not resolved.
+       ambiguous  the resolution stopped at an ambiguous level.
+       unfound    none of levels are resolved.
+  6. Number of resolved levels.
+  7. Number of levels.
+  8. The level where resolution failed, empty for full resolution.
+
+Example:
+
+    $ echo "d__Bacteria;p__Firmicutes;c__Bacilli;g__Bacillus;s__Bacillus foo" \
+        | taxonkit lineage2taxid -r
+    d__Bacteria;p__Firmicutes;c__Bacilli;g__Bacillus;s__Bacillus foo    1386    genus    partial    4    5    s__Bacillus foo
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+
+		field := getFlagPositiveInt(cmd, "lineage-field") - 1
+		delimiter := getFlagString(cmd, "delimiter")
+		if delimiter == "" {
+			checkError(fmt.Errorf("flag -d/--delimiter needed"))
+		}
+		prefixRegexp := getFlagString(cmd, "prefix-regexp")
+		var rePrefix *regexp.Regexp
+		if prefixRegexp != "" {
+			var err error
+			rePrefix, err = regexp.Compile(prefixRegexp)
+			checkError(err)
+		}
+		outputAmbigous := getFlagBool(cmd, "output-ambiguous-result")
+		printName := getFlagBool(cmd, "show-name")
+		printRank := getFlagBool(cmd, "show-rank")
+
+		files := getFileList(args)
+
+		if len(files) == 1 && isStdin(files[0]) && !xopen.IsStdin() {
+			checkError(fmt.Errorf("stdin not detected"))
+		}
+
+		outfh, err := xopen.Wopen(config.OutFile)
+		checkError(err)
+		defer outfh.Close()
+
+		// --------------------------------------------------------
+		// load data
+
+		tree, ranks, names, _, _ := loadData(config, true, printRank)
+
+		name2parent2taxid, name2taxids, ambigous := generateName2Parent2Taxid(config, tree, names)
+
+		// TaxIds are sorted once here, as these slices are shared by all workers
+		for _, _taxids := range name2taxids {
+			sort.Slice(*_taxids, func(i, j int) bool { return (*_taxids)[i] < (*_taxids)[j] })
+		}
+		for _, _taxids := range ambigous {
+			sort.Slice(_taxids, func(i, j int) bool { return _taxids[i] < _taxids[j] })
+		}
+
+		// --------------------------------------------------------
+
+		// isDescendant checks if b is a descendant of a.
+		isDescendant := func(a, b uint32) bool {
+			var parent uint32
+			for {
+				parent = tree[b]
+				if parent == a {
+					return true
+				}
+				if parent == b || parent == 0 { // root or not found
+					return false
+				}
+				b = parent
+			}
+		}
+
+		// candidates returns TaxIds of the name, with the resolved parent
+		// name and TaxId.
+		candidates := func(name, pname string, ptaxid uint32) []uint32 {
+			if ptaxid > 0 {
+				// child name -> parent name -> taxid
+				if _n2i, ok := name2parent2taxid[name]; ok {
+					if taxid, ok := _n2i[pname]; ok {
+						if _ambids, ok := ambigous[name+"__"+pname]; ok {
+							// only these under the resolved parent
+							taxids := make([]uint32, 0, len(_ambids))
+							for _, _taxid := range _ambids {
+								if isDescendant(ptaxid, _taxid) {
+									taxids = append(taxids, _taxid)
+								}
+							}
+							if len(taxids) > 0 {
+								return taxids
+							}
+						} else {
+							return []uint32{taxid}
+						}
+					}
+				}
+			}
+
+			_taxids, ok := name2taxids[name]
+			if !ok {
+				return nil
+			}
+			if ptaxid == 0 {
+				return *_taxids
+			}
+			taxids := make([]uint32, 0, 1)
+			for _, taxid := range *_taxids {
+				if isDescendant(ptaxid, taxid) {
+					taxids = append(taxids, taxid)
+				}
+			}
+			return taxids
+		}
+
+		type lineage2taxid struct {
+			line   string
+			taxid  uint32
+			status string
+			nOK    int
+			n      int
+			failed string
+		}
+
+		fn := func(line string) (interface{}, bool, error) {
+			line = strings.Trim(line, "\r\n ")
+			if line == "" || line[0] == '#' {
+				return nil, false, nil
+			}
+			data := strings.Split(line, "\t")
+			if len(data) < field+1 {
+				return nil, false, fmt.Errorf("lineage-field (%d) out of range (%d):%s", field+1, len(data), line)
+			}
+
+			// levels
+			levels := make([]string, 0, 8)
+			items := make([]string, 0, 8)
+			var item string
+			for _, level := range strings.Split(data[field], delimiter) {
+				item = strings.TrimSpace(level)
+				if rePrefix != nil {
+					item = strings.TrimSpace(rePrefix.ReplaceAllString(item, ""))
+				}
+				if item == "" {
+					continue
+				}
+				levels = append(levels, strings.TrimSpace(level))
+				items = append(items, strings.ToLower(item))
+			}
+
+			r := lineage2taxid{line: line, n: len(items)}
+
+			var taxid uint32
+			var taxids []uint32
+			var name, pname string
+			for i, item := range items {
+				name = item
+				taxids = candidates(name, pname, taxid)
+				if len(taxids) == 0 && strings.Contains(name, "_") {
+					name = strings.ReplaceAll(name, "_", " ")
+					taxids = candidates(name, pname, taxid)
+				}
+
+				if len(taxids) == 0 {
+					r.failed = levels[i]
+					break
+				}
+
+				if len(taxids) > 1 {
+					tmp := make([]string, len(taxids))
+					for _i, _taxid := range taxids {
+						tmp[_i] = strconv.Itoa(int(_taxid))
+					}
+					log.Warningf(`we can't distinguish the TaxIds (%s) for "%s" in lineage: %s. But you can use -a/--output-ambiguous-result to return one possible result`,
+						strings.Join(tmp, ", "), levels[i], data[field])
+
+					if !outputAmbigous {
+						r.status = "ambiguous"
+						r.failed = levels[i]
+						break
+					}
+				}
+
+				taxid = taxids[0]
+				pname = name
+				r.nOK++
+			}
+			r.taxid = taxid
+
+			if r.status == "" {
+				switch {
+				case r.n == 0, r.nOK == 0: // empty lineage or the first level not found
+					r.status = "unfound"
+				case r.nOK == r.n:
+					r.status = "full"
+				default:
+					r.status = "partial"
+				}
+			}
+
+			return r, true, nil
+		}
+
+		for _, file := range files {
+			reader, err := breader.NewBufferedReader(file, config.Threads, 64, fn)
+			checkError(err)
+
+			var r lineage2taxid
+			var data interface{}
+			for chunk := range reader.Ch {
+				checkError(chunk.Err)
+
+				for _, data = range chunk.Data {
+					r = data.(lineage2taxid)
+
+					outfh.WriteString(r.line)
+					outfh.WriteString("\t")
+					if r.taxid > 0 {
+						outfh.WriteString(strconv.Itoa(int(r.taxid)))
+					}
+					if printName {
+						outfh.WriteString("\t" + names[r.taxid])
+					}
+					if printRank {
+						outfh.WriteString("\t" + ranks[r.taxid])
+					}
+					outfh.WriteString(fmt.Sprintf("\t%s\t%d\t%d\t%s\n", r.status, r.nOK, r.n, r.failed))
+
+					if config.LineBuffered {
+						outfh.Flush()
+					}
+				}
+			}
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(lineage2taxidCmd)
+
+	lineage2taxidCmd.Flags().IntP("lineage-field", "i", 1, "field index of lineage. data should be tab-separated")
+	lineage2taxidCmd.Flags().StringP("delimiter", "d", ";", "delimiter of levels in lineages")
+	lineage2taxidCmd.Flags().StringP("prefix-regexp", "p", `^([A-Za-z]+(_\d+)?__|[A-Za-z]:)`, `regular expression of prefixes of levels to remove, empty for keeping them`)
+	lineage2taxidCmd.Flags().BoolP("output-ambiguous-result", "a", false, `output one of the ambigous result, i.e., the one with the smallest TaxId`)
+	lineage2taxidCmd.Flags().BoolP("show-name", "n", false, `show scientific name`)
+	lineage2taxidCmd.Flags().BoolP("show-rank", "r", false, `show rank`)
+}
@@ -23,7 +23,6 @@ package cmd
 import (
 	"bufio"
 	"fmt"
-	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -41,17 +40,37 @@ var profile2camiCmd = &cobra.Command{
 	Short: "Convert metagenomic profile table to CAMI format",
 	Long: `Convert metagenomic profile table to CAMI format
 
-Input format: 
-  1. The input file should be tab-delimited
-  2. At least two columns needed:
-     a) TaxId of taxon at species or lower rank.
-     b) Abundance (could be percentage, automatically detected or use -p/--percentage).
+Input format (-f/--input-format):
+  table (default):
+    1. The input file should be tab-delimited
+    2. At least two columns needed:
+       a) TaxId of taxon at species or lower rank.
+       b) Abundance (could be percentage, automatically detected or use -p/--percentage).
+  Reports or profiles of metagenomic classifiers, where abundances are
+  converted to relative abundances, and flags -i, -a and -p are ignored:
+    kraken2      Kraken2 report (with or without --report-minimizer-data),
+                 or Kraken-style reports of other tools, e.g., centrifuge-kreport.
+                 Reads directly assigned to taxa (the 3rd column) are used.
+    krakenuniq   KrakenUniq report. Reads directly assigned to taxa (taxReads).
+    bracken      Bracken output. Re-estimated reads (new_est_reads) are used.
+    metaphlan    MetaPhlAn 3/4 profile. Relative abundances of leaf clades are
+                 used, with TaxIds from the last ones in clade_taxid paths.
+    centrifuge   Centrifuge report. Abundances (the 7th column) are used.
+    motus        mOTUs profile with NCBI TaxIds (motus profile -p), taxa
+                 without NCBI TaxIds (NA) are ignored.
+
+  Unclassified fractions (e.g., TaxId 0 in Kraken2 reports, UNCLASSIFIED in
+  MetaPhlAn4 profiles, and unassigned in mOTUs profiles) are excluded by
+  default, i.e., relative abundances of classified taxa sum to 100%.
+  Use --count-unclassified to include them in the total.
 
 Attentions:
   1. Some TaxIds may be merged to another ones in current taxonomy version,
      the abundances will be summed up.
   2. Some TaxIds may be deleted in current taxonomy version,
      the abundances can be optionally recomputed with the flag -R/--recompute-abd.
+  3. Ranks "domain" and "acellular root" (Viruses) in NCBI taxdump files
+     since 2025 are outputted as "superkingdom" if it's in -r/--show-rank.
 
 `,
 	Run: func(cmd *cobra.Command, args []string) {
@@ -67,6 +86,19 @@ Attentions:
 
 		showRanks := getFlagStringSlice(cmd, "show-rank")
 
+		inputFormat := strings.ToLower(getFlagString(cmd, "input-format"))
+		var validFormat bool
+		for _, f := range profileFormats {
+			if inputFormat == f {
+				validFormat = true
+				break
+			}
+		}
+		if !validFormat {
+			checkError(fmt.Errorf("invalid input format: %s, available: %s", inputFormat, strings.Join(profileFormats, ", ")))
+		}
+		countUnclassified := getFlagBool(cmd, "count-unclassified")
+
 		maxField := fieldTaxid + 1
 		if fieldAbd > fieldTaxid {
 			maxField = fieldAbd + 1
@@ -157,6 +189,11 @@ Attentions:
 
 		// ----------------------------------------------------------------
 
+		// ranks are outputted from high to low
+		dbRankOrder, _, err := readRankOrder(config, "")
+		checkError(err)
+		sortRanksByOrder(showRanks, dbRankOrder)
+
 		showRanksMap := make(map[string]interface{}, 128)
 		for _, _rank := range showRanks {
 			showRanksMap[_rank] = struct{}{}
@@ -165,55 +202,90 @@ Attentions:
 		for _i, _r := range showRanks {
 			rankOrder[_r] = _i
 		}
+		// aliases of ranks, e.g., domain -> superkingdom
+		aliases := rankAliasMap(showRanks)
+		for alias, _r := range aliases {
+			showRanksMap[alias] = struct{}{}
+			rankOrder[alias] = rankOrder[_r]
+		}
 
 		// ----------------------
 
 		targets := make([]*Target, 0, 512)
 
-		n := maxField + 1
-		items := make([]string, n)
-		// var line string
-		var _taxid int
-		var taxid uint32
-		var abd float64
 		var sum float64
 
 		file := files[0]
 
-		fh, err := xopen.Ropen(file)
-		checkError(err)
+		if inputFormat == "table" {
+			n := maxField + 1
+			items := make([]string, n)
+			// var line string
+			var _taxid int
+			var taxid uint32
+			var abd float64
+			fh, err := xopen.Ropen(file)
+			checkError(err)
 
-		scanner := bufio.NewScanner(fh)
+			scanner := bufio.NewScanner(fh)
 
-		for scanner.Scan() {
-			stringSplitN(scanner.Text(), "\t", n, &items)
-			if len(items) < maxField {
-				continue
-			}
+			for scanner.Scan() {
+				stringSplitN(scanner.Text(), "\t", n, &items)
+				if len(items) < maxField {
+					continue
+				}
 
-			_taxid, err = strconv.Atoi(items[fieldTaxid])
-			if err != nil {
-				checkError(fmt.Errorf("failed to parse taxid: %s", items[fieldTaxid]))
-			}
-			taxid = uint32(_taxid)
+				_taxid, err = strconv.Atoi(items[fieldTaxid])
+				if err != nil {
+					checkError(fmt.Errorf("failed to parse taxid: %s", items[fieldTaxid]))
+				}
+				taxid = uint32(_taxid)
 
-			abd, err = strconv.ParseFloat(items[fieldAbd], 64)
-			if err != nil {
-				checkError(fmt.Errorf("failed to parse abundance: %s", items[fieldAbd]))
+				abd, err = strconv.ParseFloat(items[fieldAbd], 64)
+				if err != nil {
+					checkError(fmt.Errorf("failed to parse abundance: %s", items[fieldAbd]))
+				}
+
+				if !keepZero && abd == 0 {
+					continue
+				}
+
+				targets = append(targets, &Target{Taxid: taxid, Abundance: abd})
+				sum += abd
 			}
 
-			if !keepZero && abd == 0 {
-				continue
+			if err := scanner.Err(); err != nil {
+				checkError(err)
 			}
+			checkError(fh.Close())
+		} else {
+			_targets, unclassified, err := readClassifierReport(file, inputFormat)
+			checkError(err)
 
-			targets = append(targets, &Target{Taxid: taxid, Abundance: abd})
-			sum += abd
-		}
+			var total float64
+			for _, target := range _targets {
+				total += target.Abundance
+			}
+			if config.Verbose {
+				log.Infof("%d taxa parsed, abundance of classified: %f, unclassified: %f", len(_targets), total, unclassified)
+			}
+			if countUnclassified {
+				total += unclassified
+			}
 
-		if err := scanner.Err(); err != nil {
-			checkError(err)
+			// relative abundances
+			for _, target := range _targets {
+				if !keepZero && target.Abundance == 0 {
+					continue
+				}
+				if total > 0 {
+					target.Abundance /= total
+				}
+				targets = append(targets, target)
+				sum += target.Abundance
+			}
+			usePercentage = false
 		}
-		checkError(fh.Close())
 
 		if usePercentage || sum > 10 {
 			if config.Verbose {
@@ -268,70 +340,19 @@ Attentions:
 			nodes = append(nodes, node)
 		}
 
-		sort.Slice(nodes, func(i, j int) bool {
-			if rankOrder[nodes[i].Rank] < rankOrder[nodes[j].Rank] {
-				return true
-			}
-			if rankOrder[nodes[i].Rank] == rankOrder[nodes[j].Rank] {
-				return nodes[i].Abundance > nodes[j].Abundance
-			}
-			return false
-		})
+		sortProfileNodes(nodes, rankOrder)
 
 		// ----------------------------------------------------------------
 
-		// cami format
-		// https://github.com/bioboxes/rfc/blob/master/data-format/profiling.mkd
-
 		outfh, err := xopen.Wopen(config.OutFile)
 		checkError(err)
 		defer outfh.Close()
 
-		outfh.WriteString(fmt.Sprintf("@SampleID:%s\n", sampleID))
-		outfh.WriteString("@Version:0.10.0\n")
-		outfh.WriteString("@Ranks:superkingdom|phylum|class|order|family|genus|species|strain\n")
-		outfh.WriteString(fmt.Sprintf("@TaxonomyID:%s\n", taxonomyID))
-		outfh.WriteString("@@TAXID\tRANK\tTAXPATH\tTAXPATHSN\tPERCENTAGE\n")
-
-		var lineageTaxids, lineageNames string
-		filterByRank := len(showRanksMap) > 0
-		names := make([]string, 0, 8)
-		taxids := make([]string, 0, 8)
-		var percentage float64
-		for _, node := range nodes {
-			if filterByRank {
-				if _, ok = showRanksMap[taxdb.Rank(node.Taxid)]; !ok {
-					continue
-				}
-
-				names = names[:0]
-				taxids = taxids[:0]
-				for i, taxid := range node.LineageTaxids {
-					if _, ok = showRanksMap[taxdb.Rank(taxid)]; ok {
-						taxids = append(taxids, strconv.Itoa(int(taxid)))
-						names = append(names, node.LineageNames[i])
-					}
-				}
-				lineageTaxids = strings.Join(taxids, "|")
-				lineageNames = strings.Join(names, "|")
-			} else {
-				taxids = taxids[:0]
-				for _, taxid := range node.LineageTaxids {
-					taxids = append(taxids, strconv.Itoa(int(taxid)))
-				}
-				lineageTaxids = strings.Join(taxids, "|")
-				lineageNames = strings.Join(node.LineageNames, "|")
-			}
-
-			if usePercentage {
-				percentage = node.Abundance
-			} else {
-				percentage = node.Abundance * 100
-			}
-
-			outfh.WriteString(fmt.Sprintf("%d\t%s\t%s\t%s\t%.15f\n",
-				node.Taxid, node.Rank, lineageTaxids, lineageNames, percentage))
+		scale := float64(100)
+		if usePercentage {
+			scale = 1
 		}
+		writeCAMIProfile(outfh, taxdb, sampleID, taxonomyID, showRanks, aliases, nodes, scale)
 	},
 }
 
@@ -345,5 +366,7 @@ func init() {
 	profile2camiCmd.Flags().StringSliceP("show-rank", "r", []string{"superkingdom", "phylum", "class", "order", "family", "genus", "species", "strain"}, "only show TaxIds and names of these ranks")
 	profile2camiCmd.Flags().BoolP("keep-zero", "0", false, "keep taxons with abundance of zero")
 	profile2camiCmd.Flags().BoolP("percentage", "p", false, "abundance is in percentage")
+	profile2camiCmd.Flags().StringP("input-format", "f", "table", fmt.Sprintf(`input format, available: %s. type "taxonkit profile2cami --help" for details`, strings.Join(profileFormats, ", ")))
+	profile2camiCmd.Flags().BoolP("count-unclassified", "", false, "include unclassified fractions in the total abundance, for input formats except table")
 	profile2camiCmd.Flags().BoolP("recompute-abd", "R", false, "recompute abundance if some TaxIds are deleted in current taxonomy version")
 }
@@ -25,6 +25,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/pkg/errors"
 	"github.com/shenwei356/breader"
 	"github.com/shenwei356/util/stringutil"
 	"github.com/shenwei356/xopen"
@@ -61,7 +62,9 @@ Ambiguous names:
 
 Output format can be formated by flag --format, available placeholders:
 
-    {k}: superkingdom
+    {k}: superkingdom, or domain
+    {d}: domain, or superkingdom
+    {r}: realm
     {K}: kingdom
     {p}: phylum
     {c}: class
@@ -74,6 +77,58 @@ Output format can be formated by flag --format, available placeholders:
     {S}: subspecies
     {T}: strain
 
+  Both taxdump files before and after the NCBI rank changes in 2025 are
+  supported, where "superkingdom" was replaced with "domain". The top node
+  of viruses (Viruses, rank "acellular root" in new versions) is outputted
+  in {k}, while realms of viruses can be outputted with {r}.
+
+Placeholders of other ranks:
+
+  1. Full rank names, e.g., {rank:subfamily}, {rank:tribe}, {rank:serotype}.
+     Prefixes can be set with --prefix-rank, e.g., --prefix-rank subfamily=sf__
+  2. User-defined symbols in a tab-delimited file (--rank-symbol-file) with
+     two or three columns: symbol, rank, and optional prefix, e.g.,
+         F    subfamily    sf__
+     then {F} can be used in the format.
+  3. For these ranks, the rank order is read from the rank file
+     (~/.taxonkit/ranks.txt or --rank-file, see "taxonkit filter --help"),
+     for filling missing ranks (-F) and trimming (-T). Ranks not in the
+     rank order, e.g., "clade", are only outputted when present.
+
+Presets (--preset) of output styles for common classifiers and databases,
+which set the format, prefixes and missing-rank handling.
+Flags given explicitly (e.g., -f, -P, -F, -T, --prefix-X) take priority.
+
+    qiime2       k__Bacteria; p__Proteobacteria; ...; s__Escherichia coli
+    silva        Bacteria;Proteobacteria;...;Escherichia
+                 (missing ranks are omitted)
+    metaphlan    k__Bacteria|p__Proteobacteria|...|s__Escherichia_coli
+                 (missing ranks are filled, i.e., -F -T)
+    kraken-mpa   d__Bacteria|p__Proteobacteria|...|s__Escherichia_coli
+                 (missing ranks are omitted)
+    gtdb         d__Bacteria;p__Proteobacteria;...;s__Escherichia coli
+    sintax       d:Bacteria,p:Proteobacteria,...,s:Escherichia_coli
+                 (missing ranks are omitted)
+
+Filling missing ranks (-F):
+
+  By default, a missing rank is filled with the prefix (-p/--miss-rank-repl-prefix),
+  the name of the nearest higher taxon and the rank, e.g.,
+  "unclassified Bacillales family". The replacement can be customized with
+  --fill-template, available variables:
+
+    {rank}          the missing rank, e.g., family
+    {rank_abbr}     the first three letters of the missing rank, e.g., fam
+    {parent}        name of the nearest higher taxon, e.g., Bacillales
+    {parent_rank}   rank of the nearest higher taxon, e.g., order
+    {parent_taxid}  TaxId of the nearest higher taxon, e.g., 1385
+
+  E.g., "{parent}_{rank_abbr}_incertae_sedis" outputs "Bacillales_fam_incertae_sedis".
+
+  Stable placeholder TaxIds of filled ranks can be outputted in the TaxId
+  lineage (-t) with --fill-taxid-template, e.g., "{parent_taxid}_{rank}"
+  outputs "1385_family".
+
 When these're no nodes of rank "subspecies" nor "strain",
 you can switch on -S/--pseudo-strain to use the node with lowest rank
 as subspecies/strain name, if which rank is lower than "species". 
@@ -115,6 +170,8 @@ Output format can contains some escape charactors like "\t".
 
 		addPrefix := getFlagBool(cmd, "add-prefix")
 		prefixK := getFlagString(cmd, "prefix-k")
+		prefixD := getFlagString(cmd, "prefix-d")
+		prefixR := getFlagString(cmd, "prefix-r")
 		prefixK2 := getFlagString(cmd, "prefix-K")
 		prefixP := getFlagString(cmd, "prefix-p")
 		prefixC := getFlagString(cmd, "prefix-c")
@@ -128,8 +185,21 @@ Output format can contains some escape charactors like "\t".
 
 		trim := getFlagBool(cmd, "trim")
 
+		rankSymbolFile := getFlagString(cmd, "rank-symbol-file")
+		rankFile := getFlagString(cmd, "rank-file")
+		prefixRanks := getFlagStringSlice(cmd, "prefix-rank")
+
+		presetName := getFlagString(cmd, "preset")
+
+		fillTemplate := getFlagString(cmd, "fill-template")
+		checkError(checkFillTemplate(fillTemplate))
+		fillTaxidTemplate := getFlagString(cmd, "fill-taxid-template")
+		checkError(checkFillTemplate(fillTaxidTemplate))
+
 		prefixes := map[string]string{
 			"k": prefixK,
+			"d": prefixD,
+			"r": prefixR,
 			"K": prefixK2,
 			"p": prefixP,
 			"c": prefixC,
@@ -142,21 +212,102 @@ Output format can contains some escape charactors like "\t".
 			"T": prefixT,
 		}
 
-		// check format
-		if !reRankPlaceHolder.MatchString(format) {
-			checkError(fmt.Errorf("placeholder of simplified rank not found in output format: %s", format))
+		// preset
+		var skipMissing, underscore bool
+		var separator string
+		if presetName != "" {
+			preset, err := getReformatPreset(presetName)
+			checkError(err)
+
+			if cmd.Flags().Changed("format") {
+				log.Infof("the format of preset %s is overridden by -f/--format", preset.name)
+			} else {
+				format = preset.format()
+				skipMissing = preset.skipMissing
+				separator = preset.separator
+			}
+			underscore = preset.underscore
+
+			// flags given explicitly take priority
+			if !cmd.Flags().Changed("add-prefix") {
+				addPrefix = preset.addPrefix
+			}
+			if !cmd.Flags().Changed("fill-miss-rank") {
+				fill = preset.fill
+			}
+			if !cmd.Flags().Changed("trim") {
+				trim = preset.trim
+			}
+			for symbol, _prefix := range preset.prefixes {
+				if !cmd.Flags().Changed("prefix-" + symbol) {
+					prefixes[symbol] = _prefix
+				}
+			}
 		}
-		matches := reRankPlaceHolder.FindAllStringSubmatch(format, -1)
+
+		if fillTemplate != "" || fillTaxidTemplate != "" {
+			if !fill {
+				log.Warningf("flag --fill-template and --fill-taxid-template only work along with -F/--fill-miss-rank")
+			}
+			if fillTemplate != "" && cmd.Flags().Changed("miss-rank-repl-prefix") {
+				log.Warningf("flag -p/--miss-rank-repl-prefix is ignored when --fill-template is given")
+			}
+		}
+		if fillTaxidTemplate != "" && !printLineageInTaxid {
+			printLineageInTaxid = true
+			if config.Verbose {
+				log.Infof("-t/--show-lineage-taxids is switched on when giving flag --fill-taxid-template")
+			}
+		}
+
+		// placeholders
+		placeholders := newRankPlaceHolders()
+		if rankSymbolFile != "" {
+			checkError(placeholders.readSymbolsFile(rankSymbolFile))
+		}
+
+		// check format
+		matches, err := placeholders.parseFormat(format)
+		checkError(err)
 		flag := false
+		usedSymbols := make(map[string]interface{}, len(matches))
 		for _, match := range matches {
-			if _, ok := symbol2rank[match[1]]; !ok {
-				checkError(fmt.Errorf("invalid placeholder: %s", match[0]))
-			}
-			switch match[1] {
+			usedSymbols[match] = struct{}{}
+			switch match {
 			case "t", "S", "T":
 				flag = true
 			}
 		}
+
+		for symbol, _prefix := range placeholders.symbol2prefix {
+			prefixes[symbol] = _prefix
+		}
+		for _, pr := range prefixRanks {
+			i := strings.Index(pr, "=")
+			if i <= 0 {
+				checkError(fmt.Errorf("invalid value of --prefix-rank, format: rank=prefix: %s", pr))
+			}
+			for _, symbol := range placeholders.rank2symbols[strings.ToLower(pr[:i])] {
+				if _, ok := symbol2rank[symbol]; ok { // built-in symbols
+					continue
+				}
+				prefixes[symbol] = pr[i+1:]
+			}
+		}
+
+		if placeholders.custom {
+			rankOrder, _, err := readRankOrder(config, rankFile)
+			checkError(errors.Wrap(err, rankFile))
+			for _, symbol := range placeholders.computeWeights(rankOrder) {
+				if _, ok := usedSymbols[symbol]; !ok { // only check symbols in the format
+					continue
+				}
+				if fill || trim {
+					log.Warningf("rank order of {%s} not defined in the rank file, missing values will not be filled", symbol)
+				}
+			}
+		}
+		symbol2weight := placeholders.symbol2weight
 		if flag {
 			// do not require this.
 			// if pseudoStrain && !fill {
@@ -209,13 +360,15 @@ Output format can contains some escape charactors like "\t".
 
 		weightOfSpecies := symbol2weight["s"]
 
-		blankS := format
-		iblankS := format
-		for _, re := range reRankPlaceHolders {
-			blankS = re.ReplaceAllString(blankS, blank)
-		}
-		for _, re := range reRankPlaceHolders {
-			iblankS = re.ReplaceAllString(iblankS, iblank)
+		blankS := reRankPlaceHolder.ReplaceAllLiteralString(format, blank)
+		iblankS := reRankPlaceHolder.ReplaceAllLiteralString(format, iblank)
+		if skipMissing {
+			if blank == "" {
+				blankS = ""
+			}
+			if iblank == "" {
+				iblankS = ""
+			}
 		}
 
 		fn := func(line string) (interface{}, bool, error) {
@@ -363,17 +516,18 @@ Output format can contains some escape charactors like "\t".
 			}
 
 			for _, match := range matches {
-				replacements[match[1]] = blank
+				replacements[match] = blank
 				if printLineageInTaxid {
-					ireplacements[match[1]] = iblank
+					ireplacements[match] = iblank
 				}
 			}
 
+			var symbols []string
 			for i, name := range names {
 				rank = ranks[i]
 				taxid = taxids[i]
 
-				if srank, ok = rank2symbol[rank]; ok {
+				if symbols, ok = placeholders.rank2symbols[rank]; ok {
 					// special symbol "{t}"
 					switch rank {
 					case "strain":
@@ -390,11 +544,17 @@ Output format can contains some escape charactors like "\t".
 						srank2idx["t"] = i
 					}
 
-					replacements[srank] = name
-					if printLineageInTaxid {
-						ireplacements[srank] = strconv.Itoa(int(taxid))
+					for _, srank = range symbols {
+						replacements[srank] = name
+						if printLineageInTaxid {
+							ireplacements[srank] = strconv.Itoa(int(taxid))
+						}
+						srank2idx[srank] = i
+					}
+					srank = symbols[0]
+					if _, ok = symbol2weight[srank]; !ok { // ranks without order
+						srank = ""
 					}
-					srank2idx[srank] = i
 					// sranks[i] = srank
 					sranks = append(sranks, srank)
 
@@ -409,10 +569,7 @@ Output format can contains some escape charactors like "\t".
 			if fill {
 				var j, lastI int
 				var srank2 string
-				for _, srank = range srankList {
-					if srank == "" {
-						continue
-					}
+				for _, srank = range placeholders.symbols {
 
 					if _, ok = srank2idx[srank]; ok {
 						continue
@@ -435,7 +592,14 @@ Output format can contains some escape charactors like "\t".
 						}
 					}
 
-					replacements[srank] = prefix + names[lastI] + " " + symbol2rank[srank]
+					if fillTemplate == "" {
+						replacements[srank] = prefix + names[lastI] + " " + placeholders.symbol2rank[srank]
+					} else {
+						replacements[srank] = fillMissingRank(fillTemplate, placeholders.symbol2rank[srank], names[lastI], ranks[lastI], taxids[lastI])
+					}
+					if fillTaxidTemplate != "" {
+						ireplacements[srank] = fillMissingRank(fillTaxidTemplate, placeholders.symbol2rank[srank], names[lastI], ranks[lastI], taxids[lastI])
+					}
 					// replacements[srank] = fmt.Sprintf("%s%s %s", prefix, names[lastI], symbol2rank[srank])
 				}
 			}
@@ -446,10 +610,7 @@ Output format can contains some escape charactors like "\t".
 
 				var j, lastI int
 				var srank2 string
-				for _, srank = range srankList {
-					if srank == "" {
-						continue
-					}
+				for _, srank = range placeholders.symbols {
 
 					if _, ok = srank2idx[srank]; ok {
 						continue
@@ -481,22 +642,45 @@ Output format can contains some escape charactors like "\t".
 				}
 			}
 
-			flineage := format
-			var iflineage string
-
-			if printLineageInTaxid {
-				iflineage = format
+			if underscore {
+				for srank, name := range replacements {
+					replacements[srank] = strings.ReplaceAll(name, " ", "_")
+				}
 			}
 
-			for srank, re := range reRankPlaceHolders {
-				if addPrefix {
-					flineage = re.ReplaceAllString(flineage, prefixes[srank]+replacements[srank])
-				} else {
-					flineage = re.ReplaceAllString(flineage, replacements[srank])
+			var flineage, iflineage string
+
+			if skipMissing { // formats of presets, missing ranks are omitted
+				items := make([]string, 0, len(matches))
+				iitems := make([]string, 0, len(matches))
+				for _, srank = range matches {
+					if replacements[srank] == "" {
+						continue
+					}
+					if addPrefix {
+						items = append(items, prefixes[srank]+replacements[srank])
+					} else {
+						items = append(items, replacements[srank])
+					}
+					if printLineageInTaxid {
+						iitems = append(iitems, ireplacements[srank])
+					}
 				}
+				flineage = strings.Join(items, separator)
+				iflineage = strings.Join(iitems, separator)
+			} else {
+				flineage = reRankPlaceHolder.ReplaceAllStringFunc(format, func(s string) string {
+					s = s[1 : len(s)-1]
+					if addPrefix {
+						return prefixes[s] + replacements[s]
+					}
+					return replacements[s]
+				})
 
 				if printLineageInTaxid {
-					iflineage = re.ReplaceAllString(iflineage, ireplacements[srank])
+					iflineage = reRankPlaceHolder.ReplaceAllStringFunc(format, func(s string) string {
+						return ireplacements[s[1:len(s)-1]]
+					})
 				}
 			}
 
@@ -550,6 +734,8 @@ func init() {
 	flineageCmd.Flags().StringP("miss-taxid-repl", "R", "", `replacement string for missing taxid`)
 
 	flineageCmd.Flags().BoolP("fill-miss-rank", "F", false, "fill missing rank with lineage information of the next higher rank")
+	flineageCmd.Flags().StringP("fill-template", "", "", `template of replacements for filled missing ranks (-F), overriding -p/--miss-rank-repl-prefix. Available variables: {rank}, {rank_abbr}, {parent}, {parent_rank}, {parent_taxid}. E.g., "{parent}_{rank_abbr}_incertae_sedis"`)
+	flineageCmd.Flags().StringP("fill-taxid-template", "", "", `template of placeholder TaxIds for filled missing ranks (-F) in the TaxId lineage (-t, switched on automatically), with the same variables as --fill-template, e.g., "{parent_taxid}_{rank}"`)
 	flineageCmd.Flags().BoolP("pseudo-strain", "S", false, `use the node with lowest rank as strain name, only if which rank is lower than "species" and not "subpecies" nor "strain". It affects {t}, {S}, {T}. This flag needs flag -F`)
 
 	flineageCmd.Flags().IntP("lineage-field", "i", 2, "field index of lineage. data should be tab-separated")
@@ -559,6 +745,8 @@ func init() {
 
 	flineageCmd.Flags().BoolP("add-prefix", "P", false, `add prefixes for all ranks, single prefix for a rank is defined by flag --prefix-X`)
 	flineageCmd.Flags().StringP("prefix-k", "", "k__", `prefix for superkingdom, used along with flag -P/--add-prefix`)
+	flineageCmd.Flags().StringP("prefix-d", "", "d__", `prefix for domain, used along with flag -P/--add-prefix`)
+	flineageCmd.Flags().StringP("prefix-r", "", "r__", `prefix for realm, used along with flag -P/--add-prefix`)
 	flineageCmd.Flags().StringP("prefix-K", "", "K__", `prefix for kingdom, used along with flag -P/--add-prefix`)
 	flineageCmd.Flags().StringP("prefix-p", "", "p__", `prefix for phylum, used along with flag -P/--add-prefix`)
 	flineageCmd.Flags().StringP("prefix-c", "", "c__", `prefix for class, used along with flag -P/--add-prefix`)
@@ -571,4 +759,9 @@ func init() {
 	flineageCmd.Flags().StringP("prefix-T", "", "T__", `prefix for strain, used along with flag -P/--add-prefix`)
 
 	flineageCmd.Flags().BoolP("trim", "T", false, "do not fill missing rank lower than current rank")
+
+	flineageCmd.Flags().StringP("rank-symbol-file", "", "", `tab-delimited file of user-defined placeholders of ranks, with columns: symbol, rank, and optional prefix`)
+	flineageCmd.Flags().StringSliceP("prefix-rank", "", []string{}, `prefixes for placeholders of full rank names like {rank:subfamily}, in format of rank=prefix, e.g., subfamily=sf__`)
+	flineageCmd.Flags().StringP("preset", "", "", `output style of a classifier or reference database, available: qiime2, silva, metaphlan, kraken-mpa, gtdb, sintax. Flags given explicitly take priority`)
+	flineageCmd.Flags().StringP("rank-file", "", "", `user-defined ordered taxonomic ranks, for placeholders of other ranks, type "taxonkit filter --help" for details`)
 }
@@ -0,0 +1,207 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/shenwei356/breader"
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// relationCmd represents the relation command
+var relationCmd = &cobra.Command{
+	Use:   "relation",
+	Short: "Query ancestor/descendant relationships between TaxIds",
+	Long: `Query ancestor/descendant relationships between TaxIds
+
+Input:
+
+  - Pairs of TaxIds in two fields (-i/--taxid-field and -I/--taxid-field2)
+    of tab-delimited file or STDIN.
+  - Or TaxIds in one field (-i/--taxid-field), which are compared with
+    a set of clades given by -t/--clades or -f/--clades-file.
+
+Output:
+
+  1. Input line data.
+  2. (Optional) Status codes of TaxIds (-c/--show-status-code), the same as
+     "taxonkit lineage -c":
+     - "-1" for queries not found in whole database.
+     - "0" for deleted TaxIds, provided by "delnodes.dmp".
+     - New TaxIds for merged TaxIds, provided by "merged.dmp".
+     - Taxids for these found in "nodes.dmp".
+  3. Relation of TaxId A to TaxId B, or to each clade in the given order:
+     - "equal":      A and B are the same taxon.
+     - "ancestor":   A is an ancestor of B.
+     - "descendant": A is a descendant of B, i.e., A is within clade B.
+     - "sibling":    A and B share the same parent.
+     - "unrelated":  none of the above.
+     - "unknown":    A or B is deleted or not found.
+
+  Merged TaxIds are replaced with new ones before comparison.
+
+Examples:
+
+    $ echo -e "562\t561\n561\t562\n562\t620" | taxonkit relation
+    562     561     descendant
+    561     562     ancestor
+    562     620     unrelated
+
+    # is it a bacterium or a virus?
+    $ echo -e "562\n9606" | taxonkit relation -t 2,10239
+    562     descendant      unrelated
+    9606    unrelated       unrelated
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+
+		field := getFlagPositiveInt(cmd, "taxid-field") - 1
+		field2 := getFlagPositiveInt(cmd, "taxid-field2") - 1
+		showCode := getFlagBool(cmd, "show-status-code")
+
+		cladesFiles := getFlagStringSlice(cmd, "clades-file")
+		cladesStr := getFlagStringSlice(cmd, "clades")
+
+		files := getFileList(args)
+
+		if len(files) == 1 && isStdin(files[0]) && !xopen.IsStdin() {
+			checkError(fmt.Errorf("stdin not detected"))
+		}
+
+		// ----------------------------------------------------------------
+
+		cladesStr = append(cladesStr, readTaxidsFromFiles(config, cladesFiles)...)
+
+		cladeMode := len(cladesStr) > 0
+
+		taxondb := loadTaxonomy(&config, false, false)
+
+		clades, err := parseClades(taxondb, cladesStr)
+		checkError(err)
+		if cladeMode && config.Verbose {
+			log.Infof("%d clades loaded", len(clades))
+		}
+
+		outfh, err := xopen.Wopen(config.OutFile)
+		checkError(err)
+		defer outfh.Close()
+
+		// ----------------------------------------------------------------
+
+		maxField := field
+		if !cladeMode && field2 > maxField {
+			maxField = field2
+		}
+
+		// parse and check a TaxId, warning only once for each invalid one
+		checker := newTaxidChecker(taxondb)
+		parseTaxid := func(s string) (uint32, string, bool) {
+			_taxid, err := strconv.Atoi(s)
+			if err != nil {
+				return 0, "-1", false
+			}
+			taxid, status := checker.check(uint32(_taxid))
+			return taxid, taxidStatusCode(taxid, status), status == taxidFound || status == taxidMerged
+		}
+
+		type line2relation struct {
+			line   string
+			result string
+		}
+
+		fn := func(line string) (interface{}, bool, error) {
+			line = strings.Trim(line, "\r\n ")
+			if line == "" {
+				return nil, false, nil
+			}
+
+			data := strings.Split(line, "\t")
+			if len(data) <= maxField {
+				return nil, false, fmt.Errorf("field index (%d) out of range (%d): %s", maxField+1, len(data), line)
+			}
+
+			var buf bytes.Buffer
+
+			a, codeA, okA := parseTaxid(data[field])
+
+			if cladeMode {
+				if showCode {
+					buf.WriteString("\t" + codeA)
+				}
+				for _, b := range clades {
+					if okA {
+						buf.WriteString("\t" + taxonRelation(taxondb, a, b))
+					} else {
+						buf.WriteString("\t" + relationUnknown)
+					}
+				}
+				return line2relation{line, buf.String()}, true, nil
+			}
+
+			b, codeB, okB := parseTaxid(data[field2])
+			if showCode {
+				buf.WriteString("\t" + codeA + "\t" + codeB)
+			}
+			if okA && okB {
+				buf.WriteString("\t" + taxonRelation(taxondb, a, b))
+			} else {
+				buf.WriteString("\t" + relationUnknown)
+			}
+			return line2relation{line, buf.String()}, true, nil
+		}
+
+		for _, file := range files {
+			reader, err := breader.NewBufferedReader(file, config.Threads, 64, fn)
+			checkError(err)
+
+			var l2r line2relation
+			var data interface{}
+			for chunk := range reader.Ch {
+				checkError(chunk.Err)
+
+				for _, data = range chunk.Data {
+					l2r = data.(line2relation)
+
+					outfh.WriteString(l2r.line + l2r.result + "\n")
+					if config.LineBuffered {
+						outfh.Flush()
+					}
+				}
+			}
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(relationCmd)
+
+	relationCmd.Flags().IntP("taxid-field", "i", 1, "field index of TaxId A. input data should be tab-separated")
+	relationCmd.Flags().IntP("taxid-field2", "I", 2, "field index of TaxId B. input data should be tab-separated")
+	relationCmd.Flags().StringSliceP("clades", "t", []string{}, "TaxIds of clades to compare with, TaxIds in -i/--taxid-field are compared with each of them")
+	relationCmd.Flags().StringSliceP("clades-file", "f", []string{}, "file(s) of TaxIds of clades to compare with, one TaxId per line")
+	relationCmd.Flags().BoolP("show-status-code", "c", false, "show status codes of TaxIds before relations")
+}
@@ -0,0 +1,295 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shenwei356/bio/taxdump"
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// summarizeCmd represents the summarize command
+var summarizeCmd = &cobra.Command{
+	Use:   "summarize",
+	Short: "Summarize counts or abundances of TaxIds at given ranks",
+	Long: `Summarize counts or abundances of TaxIds at given ranks
+
+Input:
+  - Tab-delimited tables with TaxIds (-i/--taxid-field) and one or more
+    numeric columns (-f/--fields), e.g., read assignments and OTU tables.
+  - Use -H/--header-line if the first line of each file is a header line,
+    where names of numeric columns are used in the output.
+
+How to:
+  - Values of each TaxId are moved to its ancestor at the target rank(s)
+    (-r/--rank), and summed up.
+  - Values of TaxIds above the rank, or without an ancestor at the rank, are
+    kept in "unclassified" buckets of these TaxIds, e.g., "unclassified Escherichia"
+    for reads assigned to the genus when summarizing at species rank.
+  - Values of TaxIds not found in the taxonomy, deleted TaxIds, and TaxId 0
+    are summed up in the bucket "unclassified" with TaxId 0.
+  - Merged TaxIds are replaced with new ones.
+  - "superkingdom" and "domain" (NCBI taxdump files since 2025) are aliases of
+    each other.
+
+Output format:
+  Tab-delimited format with a header row.
+
+  1. rank
+  2. taxid
+  3. name
+  4. summed values of numeric columns.
+
+  Rows of each rank are sorted by the first numeric column in descending order,
+  with unclassified buckets at the end.
+
+Examples:
+  1. Read counts at species and genus ranks:
+      $ taxonkit summarize -r species,genus -f 2 read2taxid.counts.tsv
+  2. An OTU table with a header line, where the 2nd-5th columns are counts:
+      $ taxonkit summarize -H -r genus -f 2,3,4,5 otu.tsv
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+
+		field := getFlagPositiveInt(cmd, "taxid-field") - 1
+		fields := getFlagCommaSeparatedInts(cmd, "fields")
+		if len(fields) == 0 {
+			checkError(fmt.Errorf("flag -f/--fields needed"))
+		}
+		for i, f := range fields {
+			if f <= 0 {
+				checkError(fmt.Errorf("field index should be positive: %d", f))
+			}
+			fields[i] = f - 1
+		}
+		ranks := getFlagStringSlice(cmd, "rank")
+		if len(ranks) == 0 {
+			checkError(fmt.Errorf("flag -r/--rank needed"))
+		}
+		for i, rank := range ranks {
+			ranks[i] = strings.ToLower(rank)
+		}
+		headerLine := getFlagBool(cmd, "header-line")
+
+		maxField := field
+		for _, f := range fields {
+			if f > maxField {
+				maxField = f
+			}
+		}
+
+		files := getFileList(args)
+
+		if len(files) == 1 && isStdin(files[0]) && !xopen.IsStdin() {
+			checkError(fmt.Errorf("stdin not detected"))
+		}
+
+		// ----------------------------------------------------------------
+
+		taxondb := loadTaxonomy(&config, true, true)
+
+		// rank -> accepted ranks, including aliases
+		rankSets := make([]map[string]interface{}, len(ranks))
+		for i, rank := range ranks {
+			rankSets[i] = map[string]interface{}{rank: struct{}{}}
+			for _, alias := range rankAliases[rank] {
+				rankSets[i][alias] = struct{}{}
+			}
+		}
+
+		type bucket struct {
+			taxid        uint32
+			unclassified bool
+		}
+
+		// TaxId -> buckets at all ranks
+		cache := make(map[uint32][]bucket, 1024)
+		getBuckets := func(taxid uint32) []bucket {
+			if buckets, ok := cache[taxid]; ok {
+				return buckets
+			}
+
+			buckets := make([]bucket, len(ranks))
+			_taxid, ok := taxondb.TaxId(taxid)
+			if !ok || taxid == 0 {
+				if taxid != 0 {
+					log.Warningf("taxid not found or deleted: %d", taxid)
+				}
+				for i := range buckets {
+					buckets[i] = bucket{0, true}
+				}
+				cache[taxid] = buckets
+				return buckets
+			}
+
+			lineage := taxondb.LineageTaxIds(_taxid)
+			for i, rankSet := range rankSets {
+				buckets[i] = bucket{_taxid, true}
+				for _, t := range lineage {
+					if _, ok = rankSet[taxondb.Rank(t)]; ok {
+						buckets[i] = bucket{t, false}
+						break
+					}
+				}
+			}
+			cache[taxid] = buckets
+			return buckets
+		}
+
+		nValues := len(fields)
+		colnames := make([]string, nValues)
+		for i, f := range fields {
+			colnames[i] = fmt.Sprintf("field%d", f+1)
+		}
+
+		// rank -> bucket -> values
+		data := make([]map[bucket][]float64, len(ranks))
+		for i := range data {
+			data[i] = make(map[bucket][]float64, 1024)
+		}
+
+		n := maxField + 2
+		items := make([]string, n)
+		values := make([]float64, nValues)
+		var line string
+		var _taxid int
+		var i, j, lineNo int
+		var b bucket
+		var ok bool
+		var sums []float64
+		for _, file := range files {
+			fh, err := xopen.Ropen(file)
+			checkError(err)
+
+			scanner := bufio.NewScanner(fh)
+			lineNo = 0
+			for scanner.Scan() {
+				line = strings.TrimRight(scanner.Text(), "\r\n")
+				lineNo++
+				if line == "" {
+					continue
+				}
+
+				stringSplitN(line, "\t", n, &items)
+				if len(items) <= maxField {
+					checkError(fmt.Errorf("field index (%d) out of range (%d): %s", maxField+1, len(items), line))
+				}
+
+				if headerLine && lineNo == 1 {
+					for i, f := range fields {
+						colnames[i] = items[f]
+					}
+					continue
+				}
+
+				_taxid, err = strconv.Atoi(items[field])
+				if err != nil || _taxid < 0 {
+					checkError(fmt.Errorf("failed to parse taxid: %s. line: %s", items[field], line))
+				}
+
+				for i, f := range fields {
+					values[i], err = strconv.ParseFloat(items[f], 64)
+					if err != nil {
+						checkError(fmt.Errorf("failed to parse value: %s. line: %s", items[f], line))
+					}
+				}
+
+				for i, b = range getBuckets(uint32(_taxid)) {
+					if sums, ok = data[i][b]; !ok {
+						sums = make([]float64, nValues)
+						data[i][b] = sums
+					}
+					for j = range values {
+						sums[j] += values[j]
+					}
+				}
+			}
+			if err = scanner.Err(); err != nil {
+				checkError(err)
+			}
+			checkError(fh.Close())
+		}
+
+		// ----------------------------------------------------------------
+
+		outfh, err := xopen.Wopen(config.OutFile)
+		checkError(err)
+		defer outfh.Close()
+
+		outfh.WriteString("rank\ttaxid\tname\t" + strings.Join(colnames, "\t") + "\n")
+
+		for i, rank := range ranks {
+			buckets := make([]bucket, 0, len(data[i]))
+			for b = range data[i] {
+				buckets = append(buckets, b)
+			}
+			m := data[i]
+			sort.Slice(buckets, func(a, b int) bool {
+				ba, bb := buckets[a], buckets[b]
+				if ba.unclassified != bb.unclassified {
+					return !ba.unclassified
+				}
+				if (ba.taxid == 0) != (bb.taxid == 0) {
+					return bb.taxid == 0
+				}
+				if m[ba][0] != m[bb][0] {
+					return m[ba][0] > m[bb][0]
+				}
+				return ba.taxid < bb.taxid
+			})
+
+			for _, b = range buckets {
+				fmt.Fprintf(outfh, "%s\t%d\t%s", rank, b.taxid, summarizeBucketName(taxondb, b.taxid, b.unclassified))
+				for _, v := range m[b] {
+					outfh.WriteString("\t" + strconv.FormatFloat(v, 'f', -1, 64))
+				}
+				outfh.WriteString("\n")
+			}
+		}
+	},
+}
+
+func summarizeBucketName(taxondb *taxdump.Taxonomy, taxid uint32, unclassified bool) string {
+	if taxid == 0 {
+		return "unclassified"
+	}
+	if unclassified {
+		return "unclassified " + taxondb.Names[taxid]
+	}
+	return taxondb.Names[taxid]
+}
+
+func init() {
+	RootCmd.AddCommand(summarizeCmd)
+
+	summarizeCmd.Flags().IntP("taxid-field", "i", 1, "field index of TaxId. input data should be tab-separated")
+	summarizeCmd.Flags().StringP("fields", "f", "2", "comma-separated field indexes of numeric columns to summarize")
+	summarizeCmd.Flags().StringSliceP("rank", "r", []string{}, "target rank(s), e.g., species or genus,species")
+	summarizeCmd.Flags().BoolP("header-line", "H", false, "the first line of each file is a header line")
+}
@@ -0,0 +1,282 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// CAMIRecord is a data line in a CAMI profile.
+type CAMIRecord struct {
+	Taxid     uint32
+	Rank      string
+	TaxPath   []string // TaxIds of the lineage, empty for missing ranks
+	TaxPathSN []string // names of the lineage, empty for missing ranks
+	Abundance float64
+}
+
+// Name returns the taxon name, i.e., the last item of TAXPATHSN.
+func (r *CAMIRecord) Name() string {
+	if len(r.TaxPathSN) == 0 {
+		return ""
+	}
+	return r.TaxPathSN[len(r.TaxPathSN)-1]
+}
+
+// CAMISample is a sample in a CAMI profile, one file might contain multiple samples.
+type CAMISample struct {
+	SampleID   string
+	Version    string
+	Ranks      []string
+	TaxonomyID string
+	Meta       []string // all header lines
+
+	Records []*CAMIRecord
+}
+
+// Parents returns the nearest ancestor in the profile of each record,
+// 0 is returned for records without ancestors in the profile.
+func (s *CAMISample) Parents() map[uint32]uint32 {
+	present := make(map[string]interface{}, len(s.Records))
+	for _, r := range s.Records {
+		present[strconv.Itoa(int(r.Taxid))] = struct{}{}
+	}
+
+	parents := make(map[uint32]uint32, len(s.Records))
+	var i, _taxid int
+	var ok bool
+	var err error
+	for _, r := range s.Records {
+		parents[r.Taxid] = 0
+		for i = len(r.TaxPath) - 2; i >= 0; i-- {
+			if _, ok = present[r.TaxPath[i]]; !ok {
+				continue
+			}
+			_taxid, err = strconv.Atoi(r.TaxPath[i])
+			if err == nil {
+				parents[r.Taxid] = uint32(_taxid)
+			}
+			break
+		}
+	}
+	return parents
+}
+
+// camiFields stores field indexes (0-based) of a CAMI profile.
+type camiFields struct {
+	Taxid      int
+	Rank       int
+	TaxPath    int
+	TaxPathSN  int
+	Percentage int
+
+	TaxidSep string
+}
+
+func addCAMIFieldFlags(cmd *cobra.Command) {
+	cmd.Flags().IntP("field-taxid", "", 1, "field index of taxid")
+	cmd.Flags().IntP("field-rank", "", 2, "field index of rank")
+	cmd.Flags().IntP("field-taxpath", "", 3, "field index of TAXPATH")
+	cmd.Flags().IntP("field-taxpathsn", "", 4, "field index of TAXPATHSN")
+	cmd.Flags().IntP("field-percentage", "", 5, "field index of PERCENTAGE")
+
+	cmd.Flags().StringP("taxid-sep", "", "|", "separator of taxid in TAXPATH and TAXPATHSN")
+}
+
+func getCAMIFields(cmd *cobra.Command) camiFields {
+	fields := camiFields{
+		Taxid:      getFlagPositiveInt(cmd, "field-taxid") - 1,
+		Rank:       getFlagPositiveInt(cmd, "field-rank") - 1,
+		TaxPath:    getFlagPositiveInt(cmd, "field-taxpath") - 1,
+		TaxPathSN:  getFlagPositiveInt(cmd, "field-taxpathsn") - 1,
+		Percentage: getFlagPositiveInt(cmd, "field-percentage") - 1,
+		TaxidSep:   getFlagString(cmd, "taxid-sep"),
+	}
+	if fields.TaxidSep == "" {
+		checkError(fmt.Errorf("flag --taxid-sep needed and should not be empty"))
+	}
+	return fields
+}
+
+func (f camiFields) maxField() int {
+	m := f.Taxid
+	for _, i := range []int{f.Rank, f.TaxPath, f.TaxPathSN, f.Percentage} {
+		if i > m {
+			m = i
+		}
+	}
+	return m
+}
+
+// readCAMIProfile parses a CAMI profile which might contain multiple samples.
+// Records with zero abundance are ignored.
+// The file name is used as the sample ID if it's missing.
+func readCAMIProfile(file string, fields camiFields) ([]*CAMISample, error) {
+	fh, err := xopen.Ropen(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	maxField := fields.maxField() + 1
+	n := maxField + 1
+	items := make([]string, n)
+
+	samples := make([]*CAMISample, 0, 1)
+	sample := &CAMISample{}
+	var hasData bool
+
+	scanner := bufio.NewScanner(fh)
+	buf := make([]byte, 0, 1<<20)
+	scanner.Buffer(buf, 1<<26)
+
+	var line, key, value string
+	var i, _taxid int
+	var percentage float64
+	for scanner.Scan() {
+		line = strings.TrimRight(scanner.Text(), "\r\n")
+		if line == "" {
+			continue
+		}
+
+		// meta data
+		if line[0] == '#' || line[0] == '@' {
+			if hasData { // new record
+				samples = append(samples, sample)
+				sample = &CAMISample{}
+				hasData = false
+			}
+
+			sample.Meta = append(sample.Meta, line)
+
+			if line[0] == '#' || strings.HasPrefix(line, "@@") {
+				continue
+			}
+			i = strings.Index(line, ":")
+			if i < 0 {
+				continue
+			}
+			key, value = strings.TrimSpace(line[1:i]), strings.TrimSpace(line[i+1:])
+			switch strings.ToLower(key) {
+			case "sampleid":
+				sample.SampleID = value
+			case "version":
+				sample.Version = value
+			case "ranks":
+				sample.Ranks = strings.Split(value, fields.TaxidSep)
+			case "taxonomyid":
+				sample.TaxonomyID = value
+			}
+			continue
+		}
+
+		stringSplitN(line, "\t", n, &items)
+		if len(items) < maxField {
+			continue
+		}
+
+		percentage, err = strconv.ParseFloat(items[fields.Percentage], 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse abundance: %s", items[fields.Percentage])
+		}
+		if percentage == 0 {
+			continue
+		}
+
+		_taxid, err = strconv.Atoi(items[fields.Taxid])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse taxid: %s. line: %s", items[fields.Taxid], line)
+		}
+
+		hasData = true
+		sample.Records = append(sample.Records, &CAMIRecord{
+			Taxid:     uint32(_taxid),
+			Rank:      items[fields.Rank],
+			TaxPath:   strings.Split(items[fields.TaxPath], fields.TaxidSep),
+			TaxPathSN: strings.Split(items[fields.TaxPathSN], fields.TaxidSep),
+			Abundance: percentage,
+		})
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if hasData {
+		samples = append(samples, sample)
+	}
+
+	// sample IDs are missing, use the file name instead
+	var prefix string
+	if isStdin(file) {
+		prefix = "stdin"
+	} else {
+		prefix = filepath.Base(file)
+		if i = strings.Index(prefix, "."); i > 0 {
+			prefix = prefix[:i]
+		}
+	}
+	for i, sample = range samples {
+		if sample.SampleID != "" {
+			continue
+		}
+		if len(samples) == 1 {
+			sample.SampleID = prefix
+		} else {
+			sample.SampleID = fmt.Sprintf("%s_%d", prefix, i+1)
+		}
+	}
+
+	return samples, nil
+}
+
+// camiRankPrefixes are prefixes of ranks in MetaPhlAn-style lineages.
+var camiRankPrefixes = map[string]string{
+	"superkingdom":   "d__",
+	"domain":         "d__",
+	"acellular root": "d__",
+	"kingdom":        "k__",
+	"phylum":         "p__",
+	"class":          "c__",
+	"order":          "o__",
+	"family":         "f__",
+	"genus":          "g__",
+	"species":        "s__",
+	"strain":         "t__",
+	"subspecies":     "t__",
+}
+
+// camiRankPrefix returns the MetaPhlAn-style prefix of a rank.
+func camiRankPrefix(rank string) string {
+	if prefix, ok := camiRankPrefixes[rank]; ok {
+		return prefix
+	}
+	if rank == "" {
+		return "x__"
+	}
+	return strings.ToLower(rank[:1]) + "__"
+}
@@ -73,7 +73,7 @@ func getConfigs(cmd *cobra.Command) Config {
 		dataDir = getFlagString(cmd, "data-dir")
 	}
 
-	whiteList := []string{"create-taxdump", "taxid-changelog"}
+	whiteList := []string{"create-taxdump", "taxid-changelog", "cami-convert", "cami-merge", "cami-stats", "cami-validate"}
 	var skipCheckingDataDir bool
 	currentCmd := cmd.Name()
 	for _, c := range whiteList {
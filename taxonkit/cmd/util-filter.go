@@ -26,11 +26,15 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 
 	"github.com/shenwei356/bio/taxdump"
 	"github.com/shenwei356/util/pathutil"
+	"github.com/shenwei356/xopen"
 )
 
 type rankFilter struct {
@@ -60,7 +64,7 @@ type rankFilter struct {
 	cache map[uint32]bool
 }
 
-func loadTaxonomy(opt *Config, withRank bool) *taxdump.Taxonomy {
+func loadTaxonomy(opt *Config, withRank bool, withName bool) *taxdump.Taxonomy {
 
 	if opt.Verbose {
 		log.Infof("loading Taxonomy from: %s", opt.DataDir)
@@ -88,6 +92,19 @@ func loadTaxonomy(opt *Config, withRank bool) *taxdump.Taxonomy {
 	var wg sync.WaitGroup
 	wg.Add(2)
 
+	if withName {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := t.LoadNamesFromNCBI(filepath.Join(opt.DataDir, "names.dmp")); err != nil {
+				checkError(fmt.Errorf("err on loading Taxonomy names: %s", err))
+			}
+			if opt.Verbose {
+				log.Infof("%d names loaded", len(t.Names))
+			}
+		}()
+	}
+
 	go func() {
 		defer wg.Done()
 		existed, err = pathutil.Exists(filepath.Join(opt.DataDir, "delnodes.dmp"))
@@ -295,6 +312,391 @@ func (f *rankFilter) isPassed(taxid uint32) (bool, error) {
 	return pass, nil
 }
 
+// cladeFilter keeps or discards TaxIds by the clades they belong to.
+// The nearest clade decides when a TaxId is under both an included and
+// an excluded clade.
+type cladeFilter struct {
+	taxondb *taxdump.Taxonomy
+
+	includes map[uint32]struct{}
+	excludes map[uint32]struct{}
+
+	cache map[uint32]bool
+}
+
+func newCladeFilter(taxondb *taxdump.Taxonomy, includes []uint32, excludes []uint32) *cladeFilter {
+	f := &cladeFilter{
+		taxondb:  taxondb,
+		includes: make(map[uint32]struct{}, len(includes)),
+		excludes: make(map[uint32]struct{}, len(excludes)),
+		cache:    make(map[uint32]bool, 1024),
+	}
+	for _, taxid := range includes {
+		f.includes[taxid] = struct{}{}
+	}
+	for _, taxid := range excludes {
+		f.excludes[taxid] = struct{}{}
+	}
+	return f
+}
+
+func (f *cladeFilter) isPassed(taxid uint32) bool {
+	if v, ok := f.cache[taxid]; ok {
+		return v
+	}
+
+	// checking taxid, deleted or unfound ones are discarded silently
+	query := taxid
+	taxid, status := lookupTaxid(f.taxondb, taxid)
+	if status == taxidDeleted || status == taxidUnfound {
+		f.cache[query] = false
+		return false
+	}
+
+	pass := len(f.includes) == 0
+	var parent uint32
+	var ok bool
+	for {
+		if _, ok = f.excludes[taxid]; ok {
+			pass = false
+			break
+		}
+		if _, ok = f.includes[taxid]; ok {
+			pass = true
+			break
+		}
+
+		parent = f.taxondb.Nodes[taxid]
+		if parent == taxid || parent == 0 { // root
+			break
+		}
+		taxid = parent
+	}
+
+	f.cache[query] = pass
+	return pass
+}
+
+// patterns of names of environmental or unclassified entries.
+var reUnclassifiedNames = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\buncultured\b`),
+	regexp.MustCompile(`(?i)\benvironmental samples\b`),
+	regexp.MustCompile(`(?i)\bunclassified\b`),
+	regexp.MustCompile(`\bsp\.(\s|$)`),
+}
+
+// nameFilter keeps or discards TaxIds by their scientific names
+// and names in their lineages.
+type nameFilter struct {
+	taxondb *taxdump.Taxonomy
+
+	reNames    []*regexp.Regexp // for scientific names
+	reLineages []*regexp.Regexp // for all names in lineages, including the TaxIds themselves
+	invert     bool
+
+	cache map[uint32]bool
+}
+
+func newNameFilter(taxondb *taxdump.Taxonomy, nameRegexps []string, lineageRegexps []string, unclassified bool, ignoreCase bool, invert bool) (*nameFilter, error) {
+	f := &nameFilter{
+		taxondb:    taxondb,
+		reNames:    make([]*regexp.Regexp, 0, len(nameRegexps)),
+		reLineages: make([]*regexp.Regexp, 0, len(lineageRegexps)+len(reUnclassifiedNames)),
+		invert:     invert,
+		cache:      make(map[uint32]bool, 1024),
+	}
+
+	compile := func(s string) (*regexp.Regexp, error) {
+		if ignoreCase {
+			s = "(?i)" + s
+		}
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression: %s: %s", s, err)
+		}
+		return re, nil
+	}
+	for _, s := range nameRegexps {
+		re, err := compile(s)
+		if err != nil {
+			return nil, err
+		}
+		f.reNames = append(f.reNames, re)
+	}
+	for _, s := range lineageRegexps {
+		re, err := compile(s)
+		if err != nil {
+			return nil, err
+		}
+		f.reLineages = append(f.reLineages, re)
+	}
+	if unclassified {
+		f.reLineages = append(f.reLineages, reUnclassifiedNames...)
+	}
+	return f, nil
+}
+
+func (f *nameFilter) isPassed(taxid uint32) bool {
+	if v, ok := f.cache[taxid]; ok {
+		return v
+	}
+
+	// checking taxid, deleted or unfound ones are discarded silently
+	query := taxid
+	taxid, status := lookupTaxid(f.taxondb, taxid)
+	if status == taxidDeleted || status == taxidUnfound {
+		f.cache[query] = false
+		return false
+	}
+
+	pass := f.match(taxid) != f.invert
+	f.cache[query] = pass
+	return pass
+}
+
+func (f *nameFilter) match(taxid uint32) bool {
+	var re *regexp.Regexp
+	name := f.taxondb.Names[taxid]
+	for _, re = range f.reNames {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+
+	if len(f.reLineages) == 0 {
+		return false
+	}
+	var parent uint32
+	for {
+		parent = f.taxondb.Nodes[taxid]
+		if parent == taxid || parent == 0 { // root
+			break
+		}
+
+		name = f.taxondb.Names[taxid]
+		for _, re = range f.reLineages {
+			if re.MatchString(name) {
+				return true
+			}
+		}
+		taxid = parent
+	}
+	return false
+}
+
+// readTaxidsFromFiles reads TaxIds from files, one TaxId per line.
+func readTaxidsFromFiles(opt Config, files []string) []string {
+	taxids := make([]string, 0, 128)
+	for i, file := range files {
+		if opt.Verbose {
+			log.Infof("loading TaxIds from file [%d/%d]: %s", i+1, len(files), file)
+		}
+
+		fh, err := xopen.Ropen(file)
+		checkError(err)
+
+		scanner := bufio.NewScanner(fh)
+
+		var line string
+		for scanner.Scan() {
+			line = strings.TrimSpace(scanner.Text())
+			if line == "" || line[0] == '#' {
+				continue
+			}
+
+			taxids = append(taxids, line)
+		}
+
+		if err := scanner.Err(); err != nil {
+			checkError(err)
+		}
+		checkError(fh.Close())
+	}
+	return taxids
+}
+
+// parseClades parses TaxIds of clades, merged TaxIds are replaced with new ones,
+// while deleted or unfound ones are not allowed.
+func parseClades(taxondb *taxdump.Taxonomy, taxidsStr []string) ([]uint32, error) {
+	clades := make([]uint32, 0, len(taxidsStr))
+	var _taxid, status int
+	var taxid uint32
+	var err error
+	for _, s := range taxidsStr {
+		s = strings.TrimSpace(s)
+		_taxid, err = strconv.Atoi(s)
+		if err != nil || _taxid <= 0 {
+			return nil, fmt.Errorf("invalid TaxId of clade: %s", s)
+		}
+		taxid, status = lookupTaxid(taxondb, uint32(_taxid))
+		switch status {
+		case taxidDeleted:
+			return nil, fmt.Errorf("TaxId of clade was deleted: %s", s)
+		case taxidUnfound:
+			return nil, fmt.Errorf("TaxId of clade not found: %s", s)
+		case taxidMerged:
+			log.Warningf("TaxId of clade %s was merged into %d", s, taxid)
+		}
+		clades = append(clades, taxid)
+	}
+	return clades, nil
+}
+
+// inferRankOrder derives a partial order of ranks from ranks of parent-child
+// pairs in the taxonomy. Ranks without order (noRanks) are skipped, i.e.,
+// a node is compared with its nearest ancestor of a rank with order.
+// Conflicts, i.e., cycles of ranks, are resolved by removing the edges
+// supported by fewer nodes, and returned as messages.
+// Ranks of the same level are in one element of the returned levels.
+func inferRankOrder(taxondb *taxdump.Taxonomy, noRanks map[string]interface{}) ([][]string, []string, []string) {
+	isNoRank := func(rank string) bool {
+		_, ok := noRanks[rank]
+		return ok
+	}
+
+	// parent rank -> child rank -> number of nodes
+	edges := make(map[string]map[string]int, 64)
+	var rank, prank string
+	var p, pp uint32
+	var ok bool
+	for child, parent := range taxondb.Nodes {
+		if child == parent {
+			continue
+		}
+		rank = strings.ToLower(taxondb.Rank(child))
+		if isNoRank(rank) {
+			continue
+		}
+
+		// the nearest ancestor with a rank with order
+		p = parent
+		for {
+			prank = strings.ToLower(taxondb.Rank(p))
+			if !isNoRank(prank) {
+				break
+			}
+			pp = taxondb.Nodes[p]
+			if pp == p || pp == 0 { // root
+				break
+			}
+			p = pp
+		}
+		if isNoRank(prank) || prank == rank {
+			continue
+		}
+
+		if _, ok = edges[prank]; !ok {
+			edges[prank] = make(map[string]int, 8)
+		}
+		edges[prank][rank]++
+	}
+
+	// ranks
+	ranks := make(map[string]interface{}, len(taxondb.Ranks))
+	unordered := make([]string, 0, 8)
+	for rank = range taxondb.Ranks {
+		rank = strings.ToLower(rank)
+		if isNoRank(rank) {
+			unordered = append(unordered, rank)
+			continue
+		}
+		ranks[rank] = struct{}{}
+	}
+	sort.Strings(unordered)
+
+	conflicts := make([]string, 0, 8)
+
+	// conflicts of two ranks
+	var n, n2 int
+	for prank, m := range edges {
+		for rank, n = range m {
+			if n2, ok = edges[rank][prank]; !ok {
+				continue
+			}
+			if n > n2 || (n == n2 && prank < rank) {
+				conflicts = append(conflicts, fmt.Sprintf("%s > %s (%d nodes) vs %s > %s (%d nodes), the latter is ignored",
+					prank, rank, n, rank, prank, n2))
+				delete(edges[rank], prank)
+			}
+		}
+	}
+
+	// topological sorting with levels
+	indegree := make(map[string]int, len(ranks))
+	for _, m := range edges {
+		for rank = range m {
+			indegree[rank]++
+		}
+	}
+	levelOf := make(map[string]int, len(ranks))
+	done := make(map[string]interface{}, len(ranks))
+	for len(done) < len(ranks) {
+		current := make([]string, 0, 8)
+		for rank = range ranks {
+			if _, ok = done[rank]; ok {
+				continue
+			}
+			if indegree[rank] == 0 {
+				current = append(current, rank)
+			}
+		}
+
+		if len(current) == 0 { // cycles, removing edges of the rank with fewest supporting nodes
+			var minRank string
+			minN := -1
+			for rank = range ranks {
+				if _, ok = done[rank]; ok {
+					continue
+				}
+				n = 0
+				for prank, m := range edges {
+					if _, ok = done[prank]; !ok {
+						n += m[rank]
+					}
+				}
+				if minN < 0 || n < minN || (n == minN && rank < minRank) {
+					minRank, minN = rank, n
+				}
+			}
+			conflicts = append(conflicts, fmt.Sprintf("cycle found, order of %s relative to its parent ranks (%d nodes) is ignored", minRank, minN))
+			indegree[minRank] = 0
+			current = append(current, minRank)
+		}
+
+		for _, rank = range current {
+			done[rank] = struct{}{}
+		}
+		for _, prank = range current {
+			for rank = range edges[prank] {
+				if _, ok = done[rank]; ok {
+					continue
+				}
+				indegree[rank]--
+				if levelOf[prank]+1 > levelOf[rank] {
+					levelOf[rank] = levelOf[prank] + 1
+				}
+			}
+		}
+	}
+
+	var maxLevel int
+	for _, n = range levelOf {
+		if n > maxLevel {
+			maxLevel = n
+		}
+	}
+	levels := make([][]string, maxLevel+1)
+	for rank = range ranks {
+		levels[levelOf[rank]] = append(levels[levelOf[rank]], rank)
+	}
+	for _, level := range levels {
+		sort.Strings(level)
+	}
+	sort.Strings(conflicts)
+
+	return levels, unordered, conflicts
+}
+
 func readRankOrderFromFile(file string) (map[string]int, map[string]interface{}, error) {
 	fh, err := os.Open(file)
 	if err != nil {
@@ -352,6 +754,14 @@ func readRankOrderFromFile(file string) (map[string]int, map[string]interface{},
 		}
 		order++
 	}
+
+	// ranks of top nodes added in the NCBI rank changes in 2025,
+	// for rank files created before that.
+	for _, rank = range []string{"cellular root", "acellular root"} {
+		if _, ok = rankOrder[rank]; !ok {
+			noranks[rank] = struct{}{}
+		}
+	}
 	return rankOrder, noranks, nil
 }
 
@@ -404,6 +814,8 @@ const defaultRanksText = `
 
 !no rank
 !clade
+!cellular root
+!acellular root
 
 
 life
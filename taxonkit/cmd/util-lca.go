@@ -0,0 +1,370 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/shenwei356/bio/taxdump"
+)
+
+// status of TaxIds
+const (
+	taxidFound = iota
+	taxidMerged
+	taxidDeleted
+	taxidUnfound
+)
+
+// checkTaxid checks whether a TaxId is valid in the database.
+// Merged TaxIds are replaced with the new ones.
+func checkTaxid(taxondb *taxdump.Taxonomy, taxid uint32) (uint32, int) {
+	newtaxid, status := lookupTaxid(taxondb, taxid)
+	warnTaxid(taxid, newtaxid, status)
+	return newtaxid, status
+}
+
+// lookupTaxid is similar to checkTaxid(), but without logging.
+func lookupTaxid(taxondb *taxdump.Taxonomy, taxid uint32) (uint32, int) {
+	if _, ok := taxondb.Nodes[taxid]; ok {
+		return taxid, taxidFound
+	}
+	if _, ok := taxondb.DelNodes[taxid]; ok {
+		return taxid, taxidDeleted
+	}
+	if newtaxid, ok := taxondb.MergeNodes[taxid]; ok {
+		return newtaxid, taxidMerged
+	}
+	return taxid, taxidUnfound
+}
+
+func warnTaxid(taxid uint32, newtaxid uint32, status int) {
+	switch status {
+	case taxidDeleted:
+		log.Warningf("taxid %d was deleted", taxid)
+	case taxidMerged:
+		log.Warningf("taxid %d was merged into %d", taxid, newtaxid)
+	case taxidUnfound:
+		log.Warningf("taxid %d not found", taxid)
+	}
+}
+
+// taxidChecker checks TaxIds like checkTaxid(), but only warns once for
+// each merged, deleted or unfound TaxId. It is safe for concurrent use.
+type taxidChecker struct {
+	taxondb *taxdump.Taxonomy
+
+	mu     sync.Mutex
+	warned map[uint32]struct{}
+}
+
+func newTaxidChecker(taxondb *taxdump.Taxonomy) *taxidChecker {
+	return &taxidChecker{
+		taxondb: taxondb,
+		warned:  make(map[uint32]struct{}, 64),
+	}
+}
+
+func (c *taxidChecker) check(taxid uint32) (uint32, int) {
+	newtaxid, status := lookupTaxid(c.taxondb, taxid)
+	if status == taxidFound {
+		return newtaxid, status
+	}
+
+	c.mu.Lock()
+	if _, ok := c.warned[taxid]; !ok {
+		c.warned[taxid] = struct{}{}
+		warnTaxid(taxid, newtaxid, status)
+	}
+	c.mu.Unlock()
+
+	return newtaxid, status
+}
+
+// lcaRecord holds TaxIds of a line, or a group of lines sharing the same key.
+type lcaRecord struct {
+	key     string
+	taxids  []uint32
+	weights []float64
+	failed  bool // containing deleted or unfound TaxIds which are not skipped
+
+	nMerged  int
+	nDeleted int
+	nUnfound int
+}
+
+func (r *lcaRecord) reset(key string) {
+	r.key = key
+	r.taxids = r.taxids[:0]
+	if r.weights != nil {
+		r.weights = r.weights[:0]
+	}
+	r.failed = false
+	r.nMerged, r.nDeleted, r.nUnfound = 0, 0, 0
+}
+
+// lcaComputer computes the strict LCA or the consensus node of a list of TaxIds.
+// It caches lineages of TaxIds and is not safe for concurrent use.
+type lcaComputer struct {
+	taxondb *taxdump.Taxonomy
+
+	consensus bool    // consensus mode, or strict LCA
+	majority  bool    // the consensus node should be supported by > 50% of TaxIds
+	threshold float64 // minimum support (percentage) of the consensus node
+
+	topPercent float64 // only keep TaxIds with weights within this percentage of the best one
+
+	lineages map[uint32][]uint32 // taxid -> lineage taxids, root only present when querying the root itself
+	support  map[uint32]float64  // taxid -> sum of weights
+	depth    map[uint32]int      // taxid -> depth in the tree
+}
+
+func newLCAComputer(taxondb *taxdump.Taxonomy, majority bool, threshold float64, topPercent float64) *lcaComputer {
+	c := &lcaComputer{
+		taxondb:    taxondb,
+		majority:   majority,
+		threshold:  threshold,
+		topPercent: topPercent,
+	}
+	if majority || threshold < 100 {
+		c.consensus = true
+		c.lineages = make(map[uint32][]uint32, 1024)
+		c.support = make(map[uint32]float64, 128)
+		c.depth = make(map[uint32]int, 128)
+	}
+	return c
+}
+
+// filterByTopPercent removes TaxIds with weights lower than
+// (100 - topPercent)% of the maximum weight, like MEGAN does.
+// Taxids and weights are modified in place.
+func (c *lcaComputer) filterByTopPercent(taxids []uint32, weights []float64) ([]uint32, []float64) {
+	if c.topPercent <= 0 || weights == nil || len(taxids) < 2 {
+		return taxids, weights
+	}
+
+	max := weights[0]
+	for _, w := range weights[1:] {
+		if w > max {
+			max = w
+		}
+	}
+	cutoff := max * (1 - c.topPercent/100)
+
+	var j int
+	for i, w := range weights {
+		if w < cutoff {
+			continue
+		}
+		taxids[j] = taxids[i]
+		weights[j] = w
+		j++
+	}
+	return taxids[:j], weights[:j]
+}
+
+// compute returns the LCA (or consensus node) of taxids, and its support,
+// i.e., the fraction of (weighted) taxids under it.
+// All taxids should be valid in the taxonomy database.
+// weights can be nil, then all taxids are equally weighted.
+func (c *lcaComputer) compute(taxids []uint32, weights []float64) (uint32, float64) {
+	taxids, weights = c.filterByTopPercent(taxids, weights)
+
+	switch len(taxids) {
+	case 0:
+		return 0, 0
+	case 1:
+		return taxids[0], 1
+	}
+
+	if !c.consensus {
+		lca := taxids[0]
+		for _, taxid := range taxids[1:] {
+			lca = c.taxondb.LCA(lca, taxid)
+		}
+		return lca, 1
+	}
+
+	var total, w float64
+	var ok bool
+	var lineage []uint32
+	var t uint32
+	var d int
+	for i, taxid := range taxids {
+		if weights == nil {
+			w = 1
+		} else {
+			w = weights[i]
+		}
+		total += w
+
+		if lineage, ok = c.lineages[taxid]; !ok {
+			lineage = c.taxondb.LineageTaxIds(taxid)
+			c.lineages[taxid] = lineage
+		}
+
+		for d, t = range lineage {
+			if t == 1 { // the root is supported by all taxids
+				continue
+			}
+			c.support[t] += w
+			c.depth[t] = d + 1
+		}
+	}
+
+	var lca uint32 = 1
+	var bestDepth int
+	bestSupport := total
+	if total > 0 {
+		var s, frac float64
+		for t, s = range c.support {
+			frac = s / total
+			if c.majority {
+				if frac <= 0.5 {
+					continue
+				}
+			} else if frac*100 < c.threshold {
+				continue
+			}
+
+			d = c.depth[t]
+			if d > bestDepth ||
+				(d == bestDepth && (s > bestSupport || (s == bestSupport && t < lca))) {
+				lca, bestDepth, bestSupport = t, d, s
+			}
+		}
+	}
+
+	for t = range c.support {
+		delete(c.support, t)
+	}
+	for t = range c.depth {
+		delete(c.depth, t)
+	}
+
+	if total == 0 {
+		return lca, 0
+	}
+	return lca, bestSupport / total
+}
+
+// lineageTaxidsWithoutRoot returns TaxIds of the complete lineage without the root node.
+func lineageTaxidsWithoutRoot(taxondb *taxdump.Taxonomy, taxid uint32) []uint32 {
+	if taxid == 1 {
+		return nil
+	}
+	return taxondb.LineageTaxIds(taxid)
+}
+
+// taxonDistance represents the distance between two taxa.
+type taxonDistance struct {
+	lca       uint32
+	divRank   string // the highest rank (in given ranks) below the LCA
+	edges     int    // number of edges between the two taxa
+	rankSteps int    // number of nodes of given ranks between the two taxa, the LCA excluded
+}
+
+// computeTaxonDistance computes the LCA and path lengths of two valid TaxIds.
+// rankOrder is the order (smaller is higher) of canonical ranks.
+func computeTaxonDistance(taxondb *taxdump.Taxonomy, rankOrder map[string]int, a, b uint32) taxonDistance {
+	d := taxonDistance{lca: taxondb.LCA(a, b)}
+
+	divOrder := len(rankOrder)
+	var i, o int
+	var t uint32
+	var rank string
+	var ok bool
+	for _, lineage := range [][]uint32{lineageTaxidsWithoutRoot(taxondb, a), lineageTaxidsWithoutRoot(taxondb, b)} {
+		// nodes below the LCA, the root is not in the lineage
+		for i = len(lineage) - 1; i >= 0; i-- {
+			t = lineage[i]
+			if t == d.lca {
+				break
+			}
+
+			d.edges++
+			rank = taxondb.Rank(t)
+			if o, ok = rankOrder[rank]; ok {
+				d.rankSteps++
+				if o < divOrder {
+					divOrder = o
+					d.divRank = rank
+				}
+			}
+		}
+	}
+	return d
+}
+
+// isAncestor checks whether a is an ancestor of b. Both should be valid TaxIds.
+func isAncestor(taxondb *taxdump.Taxonomy, a, b uint32) bool {
+	var parent uint32
+	child := b
+	for {
+		parent = taxondb.Nodes[child]
+		if parent == a {
+			return a != b
+		}
+		if parent == child || parent == 0 { // root, or not found
+			return false
+		}
+		child = parent
+	}
+}
+
+// relations between two taxa
+const (
+	relationEqual      = "equal"
+	relationAncestor   = "ancestor"
+	relationDescendant = "descendant"
+	relationSibling    = "sibling"
+	relationUnrelated  = "unrelated"
+	relationUnknown    = "unknown"
+)
+
+// taxonRelation returns the relation of taxon a to taxon b. Both should be valid TaxIds.
+func taxonRelation(taxondb *taxdump.Taxonomy, a, b uint32) string {
+	if a == b {
+		return relationEqual
+	}
+	if isAncestor(taxondb, a, b) {
+		return relationAncestor
+	}
+	if isAncestor(taxondb, b, a) {
+		return relationDescendant
+	}
+	if taxondb.Nodes[a] == taxondb.Nodes[b] {
+		return relationSibling
+	}
+	return relationUnrelated
+}
+
+// taxidStatusCode returns the status code of a TaxId, the same as "taxonkit lineage -c".
+func taxidStatusCode(taxid uint32, status int) string {
+	switch status {
+	case taxidDeleted:
+		return "0"
+	case taxidUnfound:
+		return "-1"
+	}
+	return strconv.Itoa(int(taxid))
+}
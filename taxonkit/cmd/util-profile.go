@@ -21,9 +21,16 @@
 package cmd
 
 import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/shenwei356/bio/taxdump"
+	"github.com/shenwei356/xopen"
 )
 
 type Target struct {
@@ -120,3 +127,285 @@ func generateProfile(taxdb *taxdump.Taxonomy, targets []*Target) map[uint32]*Pro
 
 	return profile
 }
+
+// sortProfileNodes sorts nodes by rank and then abundance in descending order.
+func sortProfileNodes(nodes []*ProfileNode, rankOrder map[string]int) {
+	sort.Slice(nodes, func(i, j int) bool {
+		if rankOrder[nodes[i].Rank] < rankOrder[nodes[j].Rank] {
+			return true
+		}
+		if rankOrder[nodes[i].Rank] == rankOrder[nodes[j].Rank] {
+			return nodes[i].Abundance > nodes[j].Abundance
+		}
+		return false
+	})
+}
+
+// sortRanksByOrder sorts ranks from high to low according to the rank order,
+// ranks without order are kept right after the ranks before them.
+func sortRanksByOrder(ranks []string, rankOrder map[string]int) {
+	orders := make(map[string]int, len(ranks))
+	order := math.MaxInt32
+	var o int
+	var ok bool
+	for _, rank := range ranks {
+		if o, ok = rankOrder[strings.ToLower(rank)]; ok {
+			order = o
+		}
+		orders[rank] = order
+	}
+	sort.SliceStable(ranks, func(i, j int) bool {
+		return orders[ranks[i]] > orders[ranks[j]]
+	})
+}
+
+// writeCAMIProfile writes profile nodes in CAMI format, only taxa of showRanks
+// are outputted if given. Abundances are multiplied by scale.
+//
+// cami format
+// https://github.com/bioboxes/rfc/blob/master/data-format/profiling.mkd
+func writeCAMIProfile(outfh io.Writer, taxdb *taxdump.Taxonomy, sampleID string, taxonomyID string,
+	showRanks []string, aliases map[string]string, nodes []*ProfileNode, scale float64) {
+
+	showRanksMap := make(map[string]interface{}, 128)
+	for _, _rank := range showRanks {
+		showRanksMap[_rank] = struct{}{}
+	}
+	for alias := range aliases {
+		showRanksMap[alias] = struct{}{}
+	}
+
+	fmt.Fprintf(outfh, "@SampleID:%s\n", sampleID)
+	fmt.Fprintf(outfh, "@Version:0.10.0\n")
+	if len(showRanks) > 0 {
+		fmt.Fprintf(outfh, "@Ranks:%s\n", strings.Join(showRanks, "|"))
+	} else {
+		fmt.Fprintf(outfh, "@Ranks:superkingdom|phylum|class|order|family|genus|species|strain\n")
+	}
+	fmt.Fprintf(outfh, "@TaxonomyID:%s\n", taxonomyID)
+	fmt.Fprintf(outfh, "@@TAXID\tRANK\tTAXPATH\tTAXPATHSN\tPERCENTAGE\n")
+
+	var lineageTaxids, lineageNames string
+	filterByRank := len(showRanksMap) > 0
+	names := make([]string, 0, 8)
+	taxids := make([]string, 0, 8)
+	var rank string
+	var ok bool
+	for _, node := range nodes {
+		if filterByRank {
+			if _, ok = showRanksMap[taxdb.Rank(node.Taxid)]; !ok {
+				continue
+			}
+
+			names = names[:0]
+			taxids = taxids[:0]
+			for i, taxid := range node.LineageTaxids {
+				if _, ok = showRanksMap[taxdb.Rank(taxid)]; ok {
+					taxids = append(taxids, strconv.Itoa(int(taxid)))
+					names = append(names, node.LineageNames[i])
+				}
+			}
+			lineageTaxids = strings.Join(taxids, "|")
+			lineageNames = strings.Join(names, "|")
+		} else {
+			taxids = taxids[:0]
+			for _, taxid := range node.LineageTaxids {
+				taxids = append(taxids, strconv.Itoa(int(taxid)))
+			}
+			lineageTaxids = strings.Join(taxids, "|")
+			lineageNames = strings.Join(node.LineageNames, "|")
+		}
+
+		rank = node.Rank
+		if _r, ok := aliases[rank]; ok {
+			rank = _r
+		}
+
+		fmt.Fprintf(outfh, "%d\t%s\t%s\t%s\t%.15f\n",
+			node.Taxid, rank, lineageTaxids, lineageNames, node.Abundance*scale)
+	}
+}
+
+// formats of profiles supported by profile2cami
+var profileFormats = []string{"table", "kraken2", "krakenuniq", "bracken", "metaphlan", "centrifuge", "motus"}
+
+// readClassifierReport reads reports or profiles of metagenomic classifiers,
+// and returns raw abundances (reads or relative abundances) of taxa,
+// and the abundance of unclassified ones.
+//
+// Only abundances directly assigned to taxa are used, which are summed up to
+// the higher ranks in generateProfile.
+func readClassifierReport(file string, format string) ([]*Target, float64, error) {
+	fh, err := xopen.Ropen(file)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer fh.Close()
+
+	// for metaphlan
+	clades := make([]string, 0, 1024)
+	clade2target := make(map[string]*Target, 1024)
+
+	targets := make([]*Target, 0, 1024)
+	var unclassified float64
+
+	var line string
+	var items []string
+	var fieldTaxid, fieldAbd int
+	var _taxid int
+	var abd float64
+	var nNA int
+	scanner := bufio.NewScanner(fh)
+	buf := make([]byte, 0, 1<<20)
+	scanner.Buffer(buf, 1<<26)
+	for scanner.Scan() {
+		line = strings.TrimRight(scanner.Text(), "\r\n")
+		if line == "" {
+			continue
+		}
+		items = strings.Split(line, "\t")
+
+		switch format {
+		case "kraken2", "krakenuniq":
+			// kraken2:    %, clade reads, taxon reads, rank, taxid, name
+			// kraken2 with --report-minimizer-data:
+			//             %, clade reads, taxon reads, minimizers, distinct minimizers, rank, taxid, name
+			// krakenuniq: %, reads, taxReads, kmers, dup, cov, taxID, rank, taxName
+			if line[0] == '#' || line[0] == '%' {
+				continue
+			}
+			switch len(items) {
+			case 6:
+				fieldTaxid = 4
+			case 8, 9:
+				fieldTaxid = 6
+			default:
+				return nil, 0, fmt.Errorf("unexpected number of columns (%d) in %s report: %s", len(items), format, line)
+			}
+			fieldAbd = 2
+		case "bracken":
+			// name, taxonomy_id, taxonomy_lvl, kraken_assigned_reads, added_reads, new_est_reads, fraction_total_reads
+			if strings.HasPrefix(line, "name\t") {
+				continue
+			}
+			if len(items) < 7 {
+				return nil, 0, fmt.Errorf("unexpected number of columns (%d) in bracken output: %s", len(items), line)
+			}
+			fieldTaxid, fieldAbd = 1, 5
+		case "centrifuge":
+			// name, taxID, taxRank, genomeSize, numReads, numUniqueReads, abundance
+			if strings.HasPrefix(line, "name\t") {
+				continue
+			}
+			if len(items) < 7 {
+				return nil, 0, fmt.Errorf("unexpected number of columns (%d) in centrifuge report: %s", len(items), line)
+			}
+			fieldTaxid, fieldAbd = 1, 6
+		case "metaphlan":
+			// clade_name, clade_taxid, relative_abundance, ...
+			if line[0] == '#' {
+				continue
+			}
+			if len(items) < 3 {
+				return nil, 0, fmt.Errorf("unexpected number of columns (%d) in metaphlan profile: %s", len(items), line)
+			}
+			abd, err = strconv.ParseFloat(items[2], 64)
+			if err != nil {
+				return nil, 0, fmt.Errorf("failed to parse abundance: %s", items[2])
+			}
+			if items[0] == "UNCLASSIFIED" || items[0] == "UNKNOWN" {
+				unclassified += abd
+				continue
+			}
+
+			// the last TaxId in the path
+			taxids := strings.Split(items[1], "|")
+			var taxid string
+			for i := len(taxids) - 1; i >= 0; i-- {
+				if taxids[i] != "" {
+					taxid = taxids[i]
+					break
+				}
+			}
+			_taxid, err = strconv.Atoi(taxid)
+			if err != nil {
+				return nil, 0, fmt.Errorf("failed to parse taxid: %s", items[1])
+			}
+			clades = append(clades, items[0])
+			clade2target[items[0]] = &Target{Taxid: uint32(_taxid), Abundance: abd}
+			continue
+		case "motus":
+			// [mOTU,] consensus_taxonomy, NCBI_tax_id, sample
+			if line[0] == '#' {
+				for i, item := range items {
+					if item == "NCBI_tax_id" {
+						fieldTaxid = i
+					}
+				}
+				continue
+			}
+			if fieldTaxid == 0 {
+				fieldTaxid = len(items) - 2
+			}
+			fieldAbd = len(items) - 1
+			if fieldTaxid >= len(items) || fieldTaxid < 0 {
+				return nil, 0, fmt.Errorf("unexpected number of columns (%d) in motus profile: %s", len(items), line)
+			}
+			if items[fieldTaxid] == "NA" { // meta-mOTUs and ext-mOTUs without NCBI TaxIds
+				if abd, err = strconv.ParseFloat(items[fieldAbd], 64); err == nil && abd > 0 {
+					nNA++
+				}
+				continue
+			}
+		default:
+			return nil, 0, fmt.Errorf("unsupported input format: %s", format)
+		}
+
+		_taxid, err = strconv.Atoi(items[fieldTaxid])
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to parse taxid: %s", items[fieldTaxid])
+		}
+		abd, err = strconv.ParseFloat(strings.TrimSpace(items[fieldAbd]), 64)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to parse abundance: %s", items[fieldAbd])
+		}
+
+		if _taxid <= 0 { // 0 for kraken, -1 for motus
+			unclassified += abd
+			continue
+		}
+		targets = append(targets, &Target{Taxid: uint32(_taxid), Abundance: abd})
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	if nNA > 0 {
+		log.Warningf("%d taxa without NCBI TaxIds are ignored", nNA)
+	}
+
+	// for metaphlan, only leaves are used, as abundances are cumulative
+	if format == "metaphlan" {
+		parents := make(map[string]interface{}, len(clades))
+		var i int
+		for _, clade := range clades {
+			for {
+				i = strings.LastIndex(clade, "|")
+				if i < 0 {
+					break
+				}
+				clade = clade[:i]
+				parents[clade] = struct{}{}
+			}
+		}
+		var ok bool
+		for _, clade := range clades {
+			if _, ok = parents[clade]; ok {
+				continue
+			}
+			targets = append(targets, clade2target[clade])
+		}
+	}
+
+	return targets, unclassified, nil
+}
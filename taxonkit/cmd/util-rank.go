@@ -20,11 +20,22 @@
 
 package cmd
 
-import "regexp"
+import (
+	"bufio"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shenwei356/xopen"
+)
 
 var rankList = []string{
 	"",
 	"superkingdom",
+	"domain",
+	"realm",
 	"kingdom",
 	"phylum",
 	"class",
@@ -40,6 +51,8 @@ var rankList = []string{
 var srankList = []string{
 	"",
 	"k",
+	"d",
+	"r",
 	"K",
 	"p",
 	"c",
@@ -52,22 +65,31 @@ var srankList = []string{
 	"T",
 }
 
-var rank2symbol = map[string]string{
-	"superkingdom":      "k",
-	"kingdom":           "K",
-	"phylum":            "p",
-	"class":             "c",
-	"order":             "o",
-	"family":            "f",
-	"genus":             "g",
-	"species":           "s",
-	"subspecies/strain": "t",
-	"subspecies":        "S",
-	"strain":            "T",
+// rank2symbols maps ranks to built-in symbols. Both taxdump files before
+// and after the NCBI rank changes in 2025 are supported, where "superkingdom"
+// was replaced with "domain", and the top node of viruses (Viruses) has the
+// rank "acellular root", which is also outputted in {k}.
+var rank2symbols = map[string][]string{
+	"superkingdom":      {"k", "d"},
+	"domain":            {"d", "k"},
+	"acellular root":    {"k"},
+	"realm":             {"r"},
+	"kingdom":           {"K"},
+	"phylum":            {"p"},
+	"class":             {"c"},
+	"order":             {"o"},
+	"family":            {"f"},
+	"genus":             {"g"},
+	"species":           {"s"},
+	"subspecies/strain": {"t"},
+	"subspecies":        {"S"},
+	"strain":            {"T"},
 }
 
 var symbol2rank = map[string]string{
 	"k": "superkingdom",
+	"d": "domain",
+	"r": "realm",
 	"K": "kingdom",
 	"p": "phylum",
 	"c": "class",
@@ -81,6 +103,8 @@ var symbol2rank = map[string]string{
 }
 var symbol2weight = map[string]float32{
 	"k": 1,
+	"d": 1,
+	"r": 1.2,
 	"K": 1.5,
 	"p": 2,
 	"c": 3,
@@ -93,20 +117,405 @@ var symbol2weight = map[string]float32{
 	"T": 10,
 }
 
-var reRankPlaceHolder = regexp.MustCompile(`\{(\w)\}`)
+var reRankPlaceHolder = regexp.MustCompile(`\{(\w+|rank:[^{}]+)\}`)
 
-var reRankPlaceHolders = map[string]*regexp.Regexp{
-	"k": regexp.MustCompile(`\{k\}`),
-	"K": regexp.MustCompile(`\{K\}`),
-	"p": regexp.MustCompile(`\{p\}`),
-	"c": regexp.MustCompile(`\{c\}`),
-	"o": regexp.MustCompile(`\{o\}`),
-	"f": regexp.MustCompile(`\{f\}`),
-	"g": regexp.MustCompile(`\{g\}`),
-	"s": regexp.MustCompile(`\{s\}`),
-	"t": regexp.MustCompile(`\{t\}`),
-	"S": regexp.MustCompile(`\{S\}`),
-	"T": regexp.MustCompile(`\{T\}`),
-}
+// prefix of placeholders of full rank names, e.g., {rank:subfamily}
+const rankPlaceHolderPrefix = "rank:"
 
 const norank = "no rank"
+
+// rankPlaceHolders holds placeholders of ranks, including built-in symbols,
+// user-defined symbols, and full rank names like {rank:subfamily}.
+type rankPlaceHolders struct {
+	symbols       []string // in descending order of ranks
+	symbol2rank   map[string]string
+	rank2symbols  map[string][]string
+	symbol2weight map[string]float32
+	symbol2prefix map[string]string // prefixes of non-built-in symbols
+
+	custom bool // having non-built-in symbols
+}
+
+func newRankPlaceHolders() *rankPlaceHolders {
+	p := &rankPlaceHolders{
+		symbols:       make([]string, 0, len(srankList)),
+		symbol2rank:   make(map[string]string, len(symbol2rank)),
+		rank2symbols:  make(map[string][]string, len(rank2symbols)),
+		symbol2weight: make(map[string]float32, len(symbol2weight)),
+		symbol2prefix: make(map[string]string),
+	}
+	for _, symbol := range srankList {
+		if symbol == "" {
+			continue
+		}
+		p.symbols = append(p.symbols, symbol)
+		p.symbol2rank[symbol] = symbol2rank[symbol]
+		p.symbol2weight[symbol] = symbol2weight[symbol]
+	}
+	for rank, symbols := range rank2symbols {
+		p.rank2symbols[rank] = append([]string{}, symbols...)
+	}
+	return p
+}
+
+// addSymbol adds a non-built-in symbol.
+func (p *rankPlaceHolders) addSymbol(symbol string, rank string, prefix string) error {
+	rank = strings.ToLower(rank)
+	if _rank, ok := p.symbol2rank[symbol]; ok {
+		if _rank == rank {
+			return nil
+		}
+		return fmt.Errorf("placeholder {%s} is already used for rank: %s", symbol, _rank)
+	}
+	p.symbols = append(p.symbols, symbol)
+	p.symbol2rank[symbol] = rank
+	p.rank2symbols[rank] = append(p.rank2symbols[rank], symbol)
+	p.symbol2prefix[symbol] = prefix
+	p.custom = true
+	return nil
+}
+
+// parseFormat checks placeholders in an output format, and returns symbols found.
+// Placeholders of full rank names are added.
+func (p *rankPlaceHolders) parseFormat(format string) ([]string, error) {
+	if !reRankPlaceHolder.MatchString(format) {
+		return nil, fmt.Errorf("placeholder of rank not found in output format: %s", format)
+	}
+	if rest := reRankPlaceHolder.ReplaceAllLiteralString(format, ""); strings.ContainsAny(rest, "{}") {
+		return nil, fmt.Errorf("unmatched braces or invalid placeholders in output format: %s", format)
+	}
+	matches := reRankPlaceHolder.FindAllStringSubmatch(format, -1)
+	symbols := make([]string, 0, len(matches))
+	var err error
+	for _, match := range matches {
+		if _, ok := p.symbol2rank[match[1]]; !ok {
+			if !strings.HasPrefix(match[1], rankPlaceHolderPrefix) {
+				return nil, fmt.Errorf("invalid placeholder: %s", match[0])
+			}
+			rank := strings.TrimSpace(match[1][len(rankPlaceHolderPrefix):])
+			if rank == "" {
+				return nil, fmt.Errorf("invalid placeholder: %s", match[0])
+			}
+			if err = p.addSymbol(match[1], rank, ""); err != nil {
+				return nil, err
+			}
+		}
+		symbols = append(symbols, match[1])
+	}
+	return symbols, nil
+}
+
+// readSymbolsFile reads user-defined symbols from a tab-delimited file with
+// two or three columns: symbol, rank, and optional prefix.
+func (p *rankPlaceHolders) readSymbolsFile(file string) error {
+	fh, err := xopen.Ropen(file)
+	if err != nil {
+		return fmt.Errorf("read rank symbols from '%s': %s", file, err)
+	}
+	defer fh.Close()
+
+	scanner := bufio.NewScanner(fh)
+	var line, prefix string
+	var items []string
+	for scanner.Scan() {
+		line = strings.TrimRight(scanner.Text(), "\r\n")
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		items = strings.Split(line, "\t")
+		if len(items) < 2 {
+			return fmt.Errorf("at least two columns (symbol and rank) needed in rank symbols file: %s", line)
+		}
+		if !reSymbol.MatchString(items[0]) {
+			return fmt.Errorf("invalid symbol, only letters, digits and underscores are allowed: %s", items[0])
+		}
+		prefix = ""
+		if len(items) > 2 {
+			prefix = items[2]
+		}
+		if err = p.addSymbol(items[0], items[1], prefix); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+var reSymbol = regexp.MustCompile(`^\w+$`)
+
+// computeWeights computes weights of all symbols with a rank order
+// (bigger is higher), which is needed for non-built-in symbols.
+// Symbols of ranks absent in the rank order (e.g., "clade") have no weights,
+// they are neither used for filling missing ranks nor trimming,
+// and the unordered symbols are returned.
+func (p *rankPlaceHolders) computeWeights(rankOrder map[string]int) []string {
+	var max int
+	for _, o := range rankOrder {
+		if o > max {
+			max = o
+		}
+	}
+
+	p.symbol2weight = make(map[string]float32, len(p.symbol2rank))
+	var order int
+	var ok bool
+	for symbol, rank := range p.symbol2rank {
+		if symbol == "t" { // subspecies/strain
+			continue
+		}
+		if order, ok = rankOrder[rank]; !ok {
+			continue
+		}
+		p.symbol2weight[symbol] = float32(max - order + 1)
+	}
+	if w, ok := p.symbol2weight["s"]; ok {
+		p.symbol2weight["t"] = w + 0.5
+	}
+
+	symbols := make([]string, 0, len(p.symbols))
+	unordered := make([]string, 0, 4)
+	for _, symbol := range p.symbols {
+		if _, ok = p.symbol2weight[symbol]; ok {
+			symbols = append(symbols, symbol)
+		} else {
+			unordered = append(unordered, symbol)
+		}
+	}
+	sort.SliceStable(symbols, func(i, j int) bool {
+		return p.symbol2weight[symbols[i]] < p.symbol2weight[symbols[j]]
+	})
+	p.symbols = symbols
+	return unordered
+}
+
+// fillRankPlaceHolders replaces placeholders of ranks (e.g., "{k};{p};{s}")
+// in format with names of the lineage. Missing ranks are left empty.
+func fillRankPlaceHolders(format string, names []string, ranks []string) string {
+	rank2name := make(map[string]string, len(ranks))
+	symbol2name := make(map[string]string, len(ranks))
+	for i, rank := range ranks {
+		switch rank {
+		case "strain", "subspecies":
+			symbol2name["t"] = names[i]
+		}
+		for _, symbol := range rank2symbols[rank] {
+			symbol2name[symbol] = names[i]
+		}
+		rank2name[rank] = names[i]
+	}
+
+	return reRankPlaceHolder.ReplaceAllStringFunc(format, func(s string) string {
+		symbol := s[1 : len(s)-1]
+		if strings.HasPrefix(symbol, rankPlaceHolderPrefix) {
+			return rank2name[strings.TrimSpace(symbol[len(rankPlaceHolderPrefix):])]
+		}
+		return symbol2name[symbol]
+	})
+}
+
+// reformatPreset is a named output style of "taxonkit reformat"
+// for a classifier or reference database.
+type reformatPreset struct {
+	name string
+	desc string
+
+	symbols   []string // placeholders of ranks
+	separator string   // separator between ranks
+
+	prefixes  map[string]string
+	addPrefix bool
+	fill      bool
+	trim      bool
+
+	skipMissing bool // omitting missing ranks along with separators
+	underscore  bool // replacing spaces in names with "_"
+}
+
+// format returns the output format of the preset.
+func (p *reformatPreset) format() string {
+	placeholders := make([]string, len(p.symbols))
+	for i, s := range p.symbols {
+		placeholders[i] = "{" + s + "}"
+	}
+	return strings.Join(placeholders, p.separator)
+}
+
+var prefixesLowerCase = map[string]string{
+	"k": "k__",
+	"K": "k__",
+	"p": "p__",
+	"c": "c__",
+	"o": "o__",
+	"f": "f__",
+	"g": "g__",
+	"s": "s__",
+	"t": "t__",
+}
+
+var reformatPresets = []*reformatPreset{
+	{
+		name:      "qiime2",
+		desc:      "QIIME 2/Greengenes, k__Bacteria; p__Proteobacteria; ...; s__",
+		symbols:   []string{"k", "p", "c", "o", "f", "g", "s"},
+		separator: "; ",
+		prefixes:  prefixesLowerCase,
+		addPrefix: true,
+	},
+	{
+		name:        "silva",
+		desc:        "SILVA, Bacteria;Proteobacteria;...;Escherichia, missing ranks are omitted",
+		symbols:     []string{"k", "p", "c", "o", "f", "g"},
+		separator:   ";",
+		skipMissing: true,
+	},
+	{
+		name:      "metaphlan",
+		desc:      "MetaPhlAn, k__Bacteria|p__Proteobacteria|...|s__Escherichia_coli, missing ranks are filled",
+		symbols:   []string{"k", "p", "c", "o", "f", "g", "s"},
+		separator: "|",
+		prefixes:  prefixesLowerCase,
+		addPrefix: true,
+		fill:      true,
+		trim:      true,
+
+		underscore: true,
+	},
+	{
+		name:      "kraken-mpa",
+		desc:      "Kraken/Bracken mpa-style report, d__Bacteria|p__Proteobacteria|...|s__Escherichia_coli, missing ranks are omitted",
+		symbols:   []string{"k", "K", "p", "c", "o", "f", "g", "s"},
+		separator: "|",
+		prefixes: map[string]string{
+			"k": "d__",
+			"K": "k__",
+			"p": "p__",
+			"c": "c__",
+			"o": "o__",
+			"f": "f__",
+			"g": "g__",
+			"s": "s__",
+		},
+		addPrefix:   true,
+		skipMissing: true,
+		underscore:  true,
+	},
+	{
+		name:      "gtdb",
+		desc:      "GTDB, d__Bacteria;p__Proteobacteria;...;s__Escherichia coli",
+		symbols:   []string{"k", "p", "c", "o", "f", "g", "s"},
+		separator: ";",
+		prefixes: map[string]string{
+			"k": "d__",
+			"p": "p__",
+			"c": "c__",
+			"o": "o__",
+			"f": "f__",
+			"g": "g__",
+			"s": "s__",
+		},
+		addPrefix: true,
+	},
+	{
+		name:      "sintax",
+		desc:      "SINTAX/USEARCH/VSEARCH, d:Bacteria,p:Proteobacteria,...,s:Escherichia_coli, missing ranks are omitted",
+		symbols:   []string{"k", "p", "c", "o", "f", "g", "s"},
+		separator: ",",
+		prefixes: map[string]string{
+			"k": "d:",
+			"p": "p:",
+			"c": "c:",
+			"o": "o:",
+			"f": "f:",
+			"g": "g:",
+			"s": "s:",
+		},
+		addPrefix:   true,
+		skipMissing: true,
+		underscore:  true,
+	},
+}
+
+// getReformatPreset returns the preset of the given name.
+func getReformatPreset(name string) (*reformatPreset, error) {
+	names := make([]string, len(reformatPresets))
+	for i, p := range reformatPresets {
+		if p.name == strings.ToLower(name) {
+			return p, nil
+		}
+		names[i] = p.name
+	}
+	return nil, fmt.Errorf("invalid preset: %s, available: %s", name, strings.Join(names, ", "))
+}
+
+var reFillVariable = regexp.MustCompile(`\{(\w+)\}`)
+
+var fillVariables = map[string]struct{}{
+	"rank":         {},
+	"rank_abbr":    {},
+	"parent":       {},
+	"parent_rank":  {},
+	"parent_taxid": {},
+}
+
+// checkFillTemplate checks variables in a template for missing ranks.
+func checkFillTemplate(template string) error {
+	for _, match := range reFillVariable.FindAllStringSubmatch(template, -1) {
+		if _, ok := fillVariables[match[1]]; !ok {
+			return fmt.Errorf("invalid variable %s in template: %s", match[0], template)
+		}
+	}
+	return nil
+}
+
+// fillMissingRank generates the replacement of a missing rank with a template,
+// using the missing rank and its nearest higher taxon.
+func fillMissingRank(template string, rank string, parent string, parentRank string, parentTaxid uint32) string {
+	return reFillVariable.ReplaceAllStringFunc(template, func(s string) string {
+		switch s[1 : len(s)-1] {
+		case "rank":
+			return rank
+		case "rank_abbr":
+			if len(rank) > 3 {
+				return rank[:3]
+			}
+			return rank
+		case "parent":
+			return parent
+		case "parent_rank":
+			return parentRank
+		case "parent_taxid":
+			return strconv.Itoa(int(parentTaxid))
+		}
+		return s
+	})
+}
+
+// rankAliases are aliases of ranks, for supporting taxdump files before and
+// after the NCBI rank changes in 2025.
+var rankAliases = map[string][]string{
+	"superkingdom": {"domain", "acellular root"},
+	"domain":       {"superkingdom"},
+}
+
+// rankAliasMap returns a map of alias -> rank for a list of ranks,
+// aliases already in the list are not mapped.
+func rankAliasMap(ranks []string) map[string]string {
+	m := make(map[string]string, 4)
+	given := make(map[string]interface{}, len(ranks))
+	for _, rank := range ranks {
+		given[strings.ToLower(rank)] = struct{}{}
+	}
+	var ok bool
+	for _, rank := range ranks {
+		rank = strings.ToLower(rank)
+		for _, alias := range rankAliases[rank] {
+			if _, ok = given[alias]; ok {
+				continue
+			}
+			if _, ok = m[alias]; ok {
+				continue
+			}
+			m[alias] = rank
+		}
+	}
+	return m
+}